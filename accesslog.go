@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+)
+
+// AccessLogEntry is written as one JSON line per request when access
+// logging is enabled.
+type AccessLogEntry struct {
+	IP         string `json:"ip"`
+	RequestID  string `json:"request_id"`
+	Username   string `json:"username"`
+	Endpoint   string `json:"endpoint"`
+	StatusCode int    `json:"status_code"`
+	FetchMs    int64  `json:"fetch_ms"`
+	ProcessMs  int64  `json:"process_ms"`
+	ResizeMs   int64  `json:"resize_ms"`
+	TotalMs    int64  `json:"total_ms"`
+	CacheHit   bool   `json:"cache_hit"`
+}
+
+var accessLogger = log.New(os.Stdout, "", 0)
+
+func logAccess(entry *AccessLogEntry, timeReqStart time.Time) {
+	if !config.AccessLogging {
+		return
+	}
+
+	entry.TotalMs = timeBetween(timeReqStart, time.Now())
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Println("Unable to write access log:", err)
+		return
+	}
+	accessLogger.Println(string(data))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/applenick/appletar/render"
+	"github.com/gorilla/mux"
+	"image"
+	"net/http"
+	"net/url"
+)
+
+// armorTextureURL checks that a texture URL given to /armor/ is on one of
+// the configured hosts, so that we can't be used to fetch arbitrary URLs.
+func armorTextureURL(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("Armor texture must be fetched over HTTP, not %q", u.Scheme)
+	}
+	for _, host := range config.ArmorTextureHosts {
+		if u.Hostname() == host {
+			return u.String(), nil
+		}
+	}
+	return "", fmt.Errorf("Armor textures can't be fetched from %s", u.Hostname())
+}
+
+// armorPage renders the player's body wearing the armor textures given by
+// the ?helmet=, ?chestplate=, ?leggings= and ?boots= URLs.
+func armorPage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	size := rationalizeSize(vars["size"])
+
+	layers := make([]image.Image, len(render.ArmorPieces))
+	for i, piece := range render.ArmorPieces {
+		raw := r.URL.Query().Get(piece)
+		if raw == "" {
+			continue
+		}
+
+		textureURL, err := armorTextureURL(raw)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		texture, err := fetchSkinFromURL(r.Context(), textureURL)
+		if err != nil || !render.ValidSkinSize(texture.Image) {
+			http.Error(w, fmt.Sprintf("Unable to use %s texture", piece), http.StatusBadRequest)
+			return
+		}
+		layers[i] = texture.Image
+	}
+
+	skin, cacheStatus := fetchSkin(r.Context(), skinFetcher, vars["username"])
+	w.Header().Add("X-Cache-Status", string(cacheStatus))
+
+	img, err := render.Armored(skin, layers)
+	if err != nil {
+		serverErrorPage(w, r)
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	err = render.WritePNG(buf, render.Resize(size, 0, img, config.ResizeFilter))
+	if err != nil {
+		serverErrorPage(w, r)
+		return
+	}
+
+	w.Header().Add("Content-Type", "image/png")
+	w.Header().Add("X-Requested", "processed")
+	setResultHeaders(w, cacheStatus)
+	writeWithETag(w, r, buf.Bytes())
+}
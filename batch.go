@@ -0,0 +1,170 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/applenick/appletar/render"
+	"github.com/applenick/minecraft"
+	"image"
+	"log"
+	"net/http"
+	"regexp"
+	"sync"
+)
+
+// BatchRenderers are the renders a batch request can ask for by type.
+var BatchRenderers = map[string]func(minecraft.Skin) (image.Image, error){
+	"avatar":    render.Head,
+	"helm":      render.Helm,
+	"body":      render.Body,
+	"bust":      render.Bust,
+	"isometric": render.IsoHead,
+}
+
+// MaxBatchRequestBytes caps a batch request's JSON body, which is plenty for
+// any number of usernames config.BatchMaxUsers allows.
+const MaxBatchRequestBytes = 64 * 1024
+
+type BatchRequest struct {
+	Usernames []string `json:"usernames"`
+	Size      uint     `json:"size"`
+	Type      string   `json:"type"`
+}
+
+var batchIdentifierRegex = regexp.MustCompile("^" + ValidIdentifierRegex + "$")
+
+// batchPage renders the same image for many players at once, returning them
+// as a ZIP of <username>.png files.
+func batchPage(w http.ResponseWriter, r *http.Request) {
+	var req BatchRequest
+	r.Body = http.MaxBytesReader(w, r.Body, MaxBatchRequestBytes)
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Malformed batch request: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	if req.Type == "" {
+		req.Type = "avatar"
+	}
+	renderer, ok := BatchRenderers[req.Type]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown type %q", req.Type), http.StatusBadRequest)
+		return
+	}
+	if len(req.Usernames) == 0 {
+		http.Error(w, "No usernames given", http.StatusBadRequest)
+		return
+	}
+	if len(req.Usernames) > config.BatchMaxUsers {
+		http.Error(w, fmt.Sprintf("At most %d usernames may be requested at once", config.BatchMaxUsers), http.StatusBadRequest)
+		return
+	}
+
+	var usernames []string
+	seen := make(map[string]bool)
+	for _, username := range req.Usernames {
+		if !batchIdentifierRegex.MatchString(username) {
+			http.Error(w, fmt.Sprintf("Invalid username %q", username), http.StatusBadRequest)
+			return
+		}
+		if !seen[username] {
+			seen[username] = true
+			usernames = append(usernames, username)
+		}
+	}
+
+	size := req.Size
+	if size == 0 {
+		size = DefaultSize
+	} else if size > config.MaxSize {
+		size = config.MaxSize
+	} else if size < config.MinSize {
+		size = config.MinSize
+	}
+
+	images := renderBatch(r.Context(), usernames, size, renderer)
+
+	buf := new(bytes.Buffer)
+	archive := zip.NewWriter(buf)
+	for i, username := range usernames {
+		if images[i] == nil {
+			continue
+		}
+		f, err := archive.Create(username + ".png")
+		if err == nil {
+			_, err = f.Write(images[i])
+		}
+		if err != nil {
+			log.Println("Unable to write batch archive:", err)
+			serverErrorPage(w, r)
+			return
+		}
+	}
+	err = archive.Close()
+	if err != nil {
+		log.Println("Unable to write batch archive:", err)
+		serverErrorPage(w, r)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/zip")
+	w.Header().Add("Content-Disposition", "attachment; filename=\"batch.zip\"")
+	w.Write(buf.Bytes())
+}
+
+// renderBatch renders the PNG for each username. Any that fail to render
+// are nil.
+func renderBatch(ctx context.Context, usernames []string, size uint, renderer func(minecraft.Skin) (image.Image, error)) [][]byte {
+	images := make([][]byte, len(usernames))
+	for i, img := range renderEach(ctx, usernames, size, renderer) {
+		if img == nil {
+			continue
+		}
+
+		buf := new(bytes.Buffer)
+		err := render.WritePNG(buf, img)
+		if err != nil {
+			log.Printf("Unable to encode %s for batch: %s", usernames[i], err)
+			continue
+		}
+		images[i] = buf.Bytes()
+	}
+	return images
+}
+
+// renderEach renders the image for each username at the given size, running
+// at most config.BatchConcurrency fetches at once. Any that fail to render
+// are nil.
+func renderEach(ctx context.Context, usernames []string, size uint, renderer func(minecraft.Skin) (image.Image, error)) []image.Image {
+	concurrency := config.BatchConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	images := make([]image.Image, len(usernames))
+	for i, username := range usernames {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, username string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			skin, _ := fetchSkin(ctx, skinFetcher, username)
+			img, err := renderer(skin)
+			if err != nil {
+				log.Printf("Unable to render %s: %s", username, err)
+				return
+			}
+			images[i] = render.Resize(size, 0, img, config.ResizeFilter)
+		}(i, username)
+	}
+	wg.Wait()
+
+	return images
+}
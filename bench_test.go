@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"github.com/applenick/appletar/render"
+	"github.com/applenick/minecraft"
+	"image/png"
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+// benchSkin is the fixture skin, loaded once for all the benchmarks.
+var benchSkin minecraft.Skin
+
+func TestMain(m *testing.M) {
+	f, err := os.Open("testdata/skin.png")
+	if err != nil {
+		log.Fatalln("Unable to open fixture skin:", err)
+	}
+	img, err := png.Decode(f)
+	f.Close()
+	if err != nil {
+		log.Fatalln("Unable to decode fixture skin:", err)
+	}
+	benchSkin = minecraft.Skin{Image: img}
+
+	config = defaultConfiguration()
+	os.Exit(m.Run())
+}
+
+func BenchmarkGetHead(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		render.Head(benchSkin)
+	}
+}
+
+func BenchmarkGetHelm(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		render.Helm(benchSkin)
+	}
+}
+
+func benchmarkResize(b *testing.B, size uint) {
+	head, err := render.Head(benchSkin)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		render.Resize(size, 0, head, config.ResizeFilter)
+	}
+}
+
+func BenchmarkResize180(b *testing.B) { benchmarkResize(b, 180) }
+func BenchmarkResize64(b *testing.B)  { benchmarkResize(b, 64) }
+
+func BenchmarkFetchSkinCacheHit(b *testing.B) {
+	memoryCache = NewMemoryCache(10, time.Hour)
+	defer func() { memoryCache = nil }()
+
+	fetcher := &MockFetcher{Skins: map[string]minecraft.Skin{"notch": benchSkin}}
+	ctx := context.Background()
+	if _, status := fetchSkin(ctx, fetcher, "Notch"); status != CacheStatusNetwork {
+		b.Fatalf("Expected the first fetch to miss, got %q", status)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fetchSkin(ctx, fetcher, "Notch")
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("Mojang appears to be down, not trying them")
+
+// CircuitBreaker stops us waiting on Mojang while they are down. After
+// threshold consecutive failures it opens, failing every call immediately.
+// Once cooldown has passed a single call is let through to see whether they
+// have recovered, closing the breaker again if it succeeds.
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	open     bool
+	openedAt time.Time
+	probing  bool
+}
+
+// mojangBreaker guards fetches from Mojang, and is nil when disabled.
+var mojangBreaker *CircuitBreaker
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// Allow returns ErrCircuitOpen if the call shouldn't be made. Every allowed
+// call must be followed by a call to Record with its result, or to Release if
+// it was abandoned.
+func (b *CircuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.open {
+		return nil
+	}
+	if b.probing || time.Since(b.openedAt) < b.cooldown {
+		return ErrCircuitOpen
+	}
+	b.probing = true
+	return nil
+}
+
+// Record counts the result of a call towards opening or closing the breaker.
+func (b *CircuitBreaker) Record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !isOutage(err) {
+		if b.open {
+			log.Println("Mojang has recovered, closing circuit breaker")
+		}
+		b.failures = 0
+		b.open = false
+		b.probing = false
+		return
+	}
+
+	b.failures++
+	if b.probing || (!b.open && b.failures >= b.threshold) {
+		if !b.open {
+			log.Printf("%d consecutive failures fetching from Mojang, opening circuit breaker", b.failures)
+		}
+		b.open = true
+		b.openedAt = time.Now()
+		b.probing = false
+	}
+}
+
+// Release gives up an allowed call without a result, so that an abandoned
+// probe lets another call through rather than counting as a failure.
+func (b *CircuitBreaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// isOutage is true if err means Mojang couldn't be reached at all, rather
+// than that they answered, even if the answer was that a player doesn't
+// exist or that we are rate limited.
+func isOutage(err error) bool {
+	_, ok := err.(net.Error)
+	return ok
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerAbandonedProbe(t *testing.T) {
+	oldConfig := config
+	config = defaultConfiguration()
+	defer func() { config = oldConfig }()
+	mojangBreaker = NewCircuitBreaker(1, time.Millisecond)
+	defer func() { mojangBreaker = nil }()
+
+	mojangBreaker.Record(&net.DNSError{Err: "unreachable", IsTimeout: true})
+	time.Sleep(2 * time.Millisecond)
+
+	// The fetch becomes the probe, then its request goes away
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := fetchAndCache(ctx, blockingFetcher{}, "Notch")
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Expected the fetch to be abandoned, got %v", err)
+	}
+
+	if err := mojangBreaker.Allow(); err != nil {
+		t.Errorf("Expected another probe to be allowed after the first was abandoned, got %s", err)
+	}
+}
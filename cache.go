@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/applenick/minecraft"
+	"image/png"
+	"os"
+	"time"
+)
+
+// CacheStatus records where fetchSkin found a skin.
+type CacheStatus string
+
+const (
+	CacheStatusMemory   CacheStatus = "memory"
+	CacheStatusDisk     CacheStatus = "disk" // any SkinCache, including Redis
+	CacheStatusNetwork  CacheStatus = "network"
+	CacheStatusFallback CacheStatus = "fallback"
+	CacheStatusTile     CacheStatus = "tile" // the finished image came from StaticTileCache
+	CacheStatusUpload   CacheStatus = "upload"
+)
+
+func (s CacheStatus) IsHit() bool {
+	return s == CacheStatusMemory || s == CacheStatusDisk || s == CacheStatusTile || s == CacheStatusUpload
+}
+
+var ErrSkinExpired = errors.New("Cached skin has expired")
+
+// A SkinCache stores skins fetched from Mojang so that we don't have to hit
+// their servers for every request.
+type SkinCache interface {
+	Get(username string) (minecraft.Skin, error)
+	Save(username string, skin minecraft.Skin) error
+	Delete(username string) error
+}
+
+var skinCache SkinCache
+
+func newSkinCache(cfg MinotarConfig) (SkinCache, error) {
+	if !cfg.DiskCache {
+		return nil, nil
+	}
+
+	switch cfg.CacheBackend {
+	case "", "disk":
+		backend, err := newStorageBackend(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return StorageCache{Backend: backend}, nil
+	case "redis":
+		return NewRedisCache(cfg.RedisAddr, cfg.RedisTTLSeconds), nil
+	}
+	return nil, fmt.Errorf("Unknown cache backend %q", cfg.CacheBackend)
+}
+
+// StorageCache keeps each skin as a PNG in a StorageBackend, treating any
+// saved more than config.TTLActualSkinSeconds ago as expired.
+type StorageCache struct {
+	Backend StorageBackend
+}
+
+func (c StorageCache) Get(username string) (minecraft.Skin, error) {
+	data, savedAt, err := c.Backend.Load(username)
+	if err != nil {
+		return minecraft.Skin{}, err
+	}
+	if time.Since(savedAt) > time.Duration(config.TTLActualSkinSeconds)*time.Second {
+		return minecraft.Skin{}, ErrSkinExpired
+	}
+	return decodeSkin(data)
+}
+
+func (c StorageCache) Save(username string, skin minecraft.Skin) error {
+	data, err := encodeSkin(skin)
+	if err != nil {
+		return err
+	}
+	return c.Backend.Save(username, data)
+}
+
+func (c StorageCache) Delete(username string) error {
+	return c.Backend.Delete(username)
+}
+
+// localCacheDir returns the directory skins are cached in, if they are
+// cached on local disk at all.
+func localCacheDir(c SkinCache) (string, bool) {
+	storage, ok := c.(StorageCache)
+	if !ok {
+		return "", false
+	}
+	disk, ok := storage.Backend.(DiskBackend)
+	return disk.Dir, ok
+}
+
+// initDiskCache makes sure dir exists and that we can write skins to it.
+func initDiskCache(dir string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("Unable to create skin cache directory: %s", err)
+	}
+	err = checkWritable(dir)
+	if err != nil {
+		return fmt.Errorf("Skin cache directory %s is not writable: %s", dir, err)
+	}
+	return nil
+}
+
+func encodeSkin(skin minecraft.Skin) ([]byte, error) {
+	if skin.Image == nil {
+		return nil, errors.New("Skin has no image")
+	}
+
+	buf := new(bytes.Buffer)
+	err := png.Encode(buf, skin.Image)
+	return buf.Bytes(), err
+}
+
+func decodeSkin(data []byte) (minecraft.Skin, error) {
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return minecraft.Skin{}, err
+	}
+	return minecraft.Skin{Image: img}, nil
+}
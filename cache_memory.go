@@ -0,0 +1,109 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"github.com/applenick/minecraft"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrNotCached = errors.New("Skin not in cache")
+
+// MemoryCache is a fixed-size, least-recently-used cache of decoded skins.
+// It sits in front of the configured SkinCache so hot skins never touch
+// disk or the network. Usernames are case insensitive.
+type MemoryCache struct {
+	capacity int
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type memoryCacheEntry struct {
+	username string
+	skin     minecraft.Skin
+	cachedAt time.Time
+}
+
+var memoryCache *MemoryCache
+
+func NewMemoryCache(capacity int, ttl time.Duration) *MemoryCache {
+	return &MemoryCache{
+		capacity: capacity,
+		ttl:      ttl,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (c *MemoryCache) Get(username string) (minecraft.Skin, error) {
+	skin, _, err := c.GetWithAge(username)
+	return skin, err
+}
+
+// GetWithAge returns a cached skin along with how long ago it was cached.
+func (c *MemoryCache) GetWithAge(username string) (minecraft.Skin, time.Duration, error) {
+	username = strings.ToLower(username)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[username]
+	if !ok {
+		return minecraft.Skin{}, 0, ErrNotCached
+	}
+
+	entry := elem.Value.(*memoryCacheEntry)
+	age := time.Since(entry.cachedAt)
+	if age > c.ttl {
+		c.removeElement(elem)
+		return minecraft.Skin{}, 0, ErrSkinExpired
+	}
+
+	c.order.MoveToFront(elem)
+	return entry.skin, age, nil
+}
+
+func (c *MemoryCache) Save(username string, skin minecraft.Skin) error {
+	username = strings.ToLower(username)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[username]; ok {
+		entry := elem.Value.(*memoryCacheEntry)
+		entry.skin = skin
+		entry.cachedAt = time.Now()
+		c.order.MoveToFront(elem)
+		return nil
+	}
+
+	entry := &memoryCacheEntry{username: username, skin: skin, cachedAt: time.Now()}
+	c.entries[username] = c.order.PushFront(entry)
+
+	for c.order.Len() > c.capacity {
+		c.removeElement(c.order.Back())
+	}
+	return nil
+}
+
+func (c *MemoryCache) Delete(username string) error {
+	username = strings.ToLower(username)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[username]; ok {
+		c.removeElement(elem)
+	}
+	return nil
+}
+
+func (c *MemoryCache) removeElement(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*memoryCacheEntry).username)
+}
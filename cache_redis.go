@@ -0,0 +1,61 @@
+package main
+
+import (
+	"github.com/applenick/minecraft"
+	"github.com/gomodule/redigo/redis"
+	"time"
+)
+
+const (
+	RedisKeyPrefix = "minotar:skin:"
+)
+
+// RedisCache stores the raw skin PNGs in Redis, letting Redis expire them
+// once they are older than the configured TTL.
+type RedisCache struct {
+	pool *redis.Pool
+	ttl  uint
+}
+
+func NewRedisCache(addr string, ttl uint) *RedisCache {
+	pool := &redis.Pool{
+		MaxIdle:     8,
+		IdleTimeout: 240 * time.Second,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", addr)
+		},
+	}
+	return &RedisCache{pool: pool, ttl: ttl}
+}
+
+func (c *RedisCache) Get(username string) (minecraft.Skin, error) {
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	data, err := redis.Bytes(conn.Do("GET", RedisKeyPrefix+username))
+	if err != nil {
+		return minecraft.Skin{}, err
+	}
+	return decodeSkin(data)
+}
+
+func (c *RedisCache) Save(username string, skin minecraft.Skin) error {
+	data, err := encodeSkin(skin)
+	if err != nil {
+		return err
+	}
+
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	_, err = conn.Do("SETEX", RedisKeyPrefix+username, c.ttl, data)
+	return err
+}
+
+func (c *RedisCache) Delete(username string) error {
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("DEL", RedisKeyPrefix+username)
+	return err
+}
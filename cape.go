@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/applenick/appletar/render"
+	"github.com/applenick/minecraft"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strings"
+)
+
+const (
+	// Capes share the skin cache, under keys no username can collide with
+	CapeCachePrefix = "cape-"
+)
+
+// CapeFetcher fetches capes from Mojang, wrapped up as skins so that they
+// are cached like them. It is asked for CapeCachePrefix followed by the
+// username. Players without a cape get a transparent texture, so that
+// knowing they have none is cached too.
+type CapeFetcher struct{}
+
+func (CapeFetcher) Fetch(ctx context.Context, key string) (minecraft.Skin, error) {
+	textures, err := fetchProfileTextures(ctx, strings.TrimPrefix(key, CapeCachePrefix))
+	if err != nil {
+		return minecraft.Skin{}, err
+	}
+	if textures.Textures.Cape.URL == "" {
+		img, err := render.Cape(minecraft.Skin{})
+		return minecraft.Skin{Image: img}, err
+	}
+	return fetchSkinFromURL(ctx, textures.Textures.Cape.URL)
+}
+
+// newCapeChain builds the chain fetchCape uses: the memory cache, then the
+// skin cache, then Mojang, so capes share the breaker and coalescing of
+// skin fetches.
+func newCapeChain() FetchChain {
+	var sources []SkinSource
+	if memoryCache != nil {
+		sources = append(sources, MemorySource{Cache: memoryCache, Fetcher: CapeFetcher{}})
+	}
+	if skinCache != nil {
+		sources = append(sources, CacheSource{Cache: skinCache})
+	}
+	return FetchChain{Sources: append(sources, FetcherSource{Fetcher: CapeFetcher{}})}
+}
+
+// fetchCape returns the cape texture of a username or UUID. The status is
+// CacheStatusFallback, with a nil image, if it couldn't be fetched.
+func fetchCape(ctx context.Context, identifier string) (minecraft.Skin, CacheStatus) {
+	_, username := normalizeIdentifier(identifier)
+	cape, source, _ := newCapeChain().Fetch(ctx, CapeCachePrefix+username)
+	if source == nil {
+		return minecraft.Skin{}, CacheStatusFallback
+	}
+	return cape, source.Status()
+}
+
+func capePage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	size := rationalizeSize(vars["size"])
+
+	cape, cacheStatus := fetchCape(r.Context(), vars["username"])
+	w.Header().Add("X-Cache-Status", string(cacheStatus))
+
+	img, err := render.Cape(cape)
+	if err != nil {
+		serverErrorPage(w, r)
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	err = render.WritePNG(buf, render.Resize(size, 0, img, config.ResizeFilter))
+	if err != nil {
+		serverErrorPage(w, r)
+		return
+	}
+
+	w.Header().Add("Content-Type", "image/png")
+	w.Header().Add("X-Requested", "cape")
+	setResultHeaders(w, cacheStatus)
+	writeWithETag(w, r, buf.Bytes())
+}
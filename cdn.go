@@ -0,0 +1,39 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// CDNSecretHeader carries config.CDNSecret on the CDN's own requests, which
+// must be served rather than redirected back to it.
+const CDNSecretHeader = "X-CDN-Secret"
+
+// cdnURL is where the CDN serves the same image as r.
+func cdnURL(r *http.Request) string {
+	return strings.TrimSuffix(config.CDNBaseURL, "/") + r.URL.RequestURI()
+}
+
+// fromCDN is true if r was made by the CDN to populate its cache.
+func fromCDN(r *http.Request) bool {
+	given := r.Header.Get(CDNSecretHeader)
+	return given != "" && subtle.ConstantTimeCompare([]byte(given), []byte(config.CDNSecret)) == 1
+}
+
+// CDNMiddleware sends clients to the CDN's copy of the image when a CDN is
+// configured, serving the CDN itself as usual.
+func CDNMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.CDNBaseURL == "" || fromCDN(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		status := http.StatusFound
+		if config.CDNPermanentRedirect {
+			status = http.StatusMovedPermanently
+		}
+		http.Redirect(w, r, cdnURL(r), status)
+	})
+}
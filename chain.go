@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/applenick/appletar/render"
+	"github.com/applenick/minecraft"
+	"log"
+	"time"
+)
+
+// A SkinSource is somewhere a FetchChain can look for a skin.
+type SkinSource interface {
+	// FetchSkin returns the skin for username, with found false if this
+	// source doesn't have it. An error explains why it wasn't found.
+	FetchSkin(ctx context.Context, username string) (skin minecraft.Skin, found bool, err error)
+	// Status is reported as the X-Cache-Status of skins this source found
+	Status() CacheStatus
+}
+
+// FetchChain tries each of its Sources in turn until one finds the skin.
+type FetchChain struct {
+	Sources []SkinSource
+}
+
+// Fetch returns the first skin found and the source that found it, which is
+// nil if none did. errs holds the error of every source that failed.
+func (c FetchChain) Fetch(ctx context.Context, username string) (skin minecraft.Skin, source SkinSource, errs []error) {
+	for _, source := range c.Sources {
+		start := time.Now()
+		skin, found, err := source.FetchSkin(ctx, username)
+		debug(ctx, "Tried skin source", "username", username, "source", source.Status(),
+			"found", found, "error", err, "ms", timeBetween(start, time.Now()))
+		if found {
+			return skin, source, errs
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return minecraft.Skin{}, nil, errs
+}
+
+// newFetchChain builds the chain fetchSkin uses: the memory cache, then the
+// skin cache, then any uploaded skin, then fetcher, then the fallback skins.
+func newFetchChain(fetcher SkinFetcher) FetchChain {
+	var sources []SkinSource
+	if memoryCache != nil {
+		sources = append(sources, MemorySource{Cache: memoryCache, Fetcher: fetcher})
+	}
+	if skinCache != nil {
+		sources = append(sources, CacheSource{Cache: skinCache})
+	}
+	if uploadStorage != nil {
+		sources = append(sources, UploadSource{Storage: uploadStorage})
+	}
+	sources = append(sources, FetcherSource{Fetcher: fetcher})
+	return FetchChain{Sources: append(sources, fallbackChain.Sources...)}
+}
+
+// fallbackChain finds the skin served for players who don't have one.
+var fallbackChain = FetchChain{Sources: []SkinSource{FallbackURLSource{}, CharSource{}}}
+
+// MemorySource finds skins in a MemoryCache, refreshing stale ones from
+// Fetcher in the background.
+type MemorySource struct {
+	Cache   *MemoryCache
+	Fetcher SkinFetcher
+}
+
+func (s MemorySource) FetchSkin(ctx context.Context, username string) (minecraft.Skin, bool, error) {
+	skin, age, err := s.Cache.GetWithAge(username)
+	if err != nil {
+		return minecraft.Skin{}, false, err
+	}
+	// Past half its TTL the skin is refreshed in the background while the
+	// cached copy is served
+	if age > time.Duration(config.TTLActualSkinSeconds/2)*time.Second {
+		refreshSkin(ctx, s.Fetcher, username)
+	}
+	return skin, true, nil
+}
+
+func (MemorySource) Status() CacheStatus { return CacheStatusMemory }
+
+// CacheSource finds skins in a SkinCache, keeping those it finds in memory.
+type CacheSource struct {
+	Cache SkinCache
+}
+
+func (s CacheSource) FetchSkin(ctx context.Context, username string) (minecraft.Skin, bool, error) {
+	skin, err := s.Cache.Get(username)
+	if err != nil {
+		return minecraft.Skin{}, false, err
+	}
+	cacheInMemory(username, skin)
+	return skin, true, nil
+}
+
+func (CacheSource) Status() CacheStatus { return CacheStatusDisk }
+
+// FetcherSource fetches skins with a SkinFetcher, saving them in the caches.
+type FetcherSource struct {
+	Fetcher SkinFetcher
+}
+
+func (s FetcherSource) FetchSkin(ctx context.Context, username string) (minecraft.Skin, bool, error) {
+	skin, err := fetchAndCache(ctx, s.Fetcher, username)
+	if err != nil {
+		if isTimeout(err) {
+			log.Printf("Timed out fetching skin for %s: %s", username, err)
+		}
+		return minecraft.Skin{}, false, err
+	}
+	return skin, true, nil
+}
+
+func (FetcherSource) Status() CacheStatus { return CacheStatusNetwork }
+
+// FallbackURLSource serves the skin at config.FallbackSkinURL, if set.
+type FallbackURLSource struct{}
+
+func (FallbackURLSource) FetchSkin(ctx context.Context, username string) (minecraft.Skin, bool, error) {
+	if config.FallbackSkinURL == "" {
+		return minecraft.Skin{}, false, nil
+	}
+
+	skin, err := fetchSkinFromURL(ctx, config.FallbackSkinURL)
+	if err == nil && !render.ValidSkinSize(skin.Image) {
+		err = errors.New("Fallback skin has invalid dimensions")
+	}
+	if err != nil {
+		log.Println("Unable to use fallback skin, serving char:", err)
+		return minecraft.Skin{}, false, err
+	}
+	return skin, true, nil
+}
+
+func (FallbackURLSource) Status() CacheStatus { return CacheStatusFallback }
+
+// CharSource serves char, the default skin.
+type CharSource struct{}
+
+func (CharSource) FetchSkin(ctx context.Context, username string) (minecraft.Skin, bool, error) {
+	skin, err := minecraft.FetchSkinForChar()
+	return skin, err == nil, err
+}
+
+func (CharSource) Status() CacheStatus { return CacheStatusFallback }
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"compress/gzip"
+	"github.com/andybalholm/brotli"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// CompressMiddleware compresses JSON responses with gzip, or brotli when
+// config.BrotliEnabled, if the client accepts it. Images are left alone as
+// they are already compressed.
+func CompressMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == "HEAD" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiateEncoding picks the encoding to use for a client sending the given
+// Accept-Encoding, or "" if it accepts none we support.
+func negotiateEncoding(header string) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if len(fields) > 1 && strings.Replace(fields[1], " ", "", -1) == "q=0" {
+			continue
+		}
+		accepted[name] = true
+	}
+
+	if config.BrotliEnabled && accepted["br"] {
+		return "br"
+	} else if accepted["gzip"] {
+		return "gzip"
+	}
+	return ""
+}
+
+// compressWriter decides whether to compress once the handler has set its
+// headers, passing anything that isn't JSON straight through.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+
+	wroteHeader bool
+	encoder     io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+
+	h := cw.Header()
+	if strings.HasPrefix(h.Get("Content-Type"), "application/json") {
+		h.Add("Vary", "Accept-Encoding")
+		if h.Get("Content-Encoding") == "" && code != http.StatusNoContent && code != http.StatusNotModified {
+			h.Set("Content-Encoding", cw.encoding)
+			h.Del("Content-Length")
+			if cw.encoding == "br" {
+				cw.encoder = brotli.NewWriterLevel(cw.ResponseWriter, brotli.DefaultCompression)
+			} else {
+				cw.encoder = gzip.NewWriter(cw.ResponseWriter)
+			}
+		}
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.encoder != nil {
+		return cw.encoder.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// Flush keeps streaming responses such as /skin/{username}/events working
+// through the middleware.
+func (cw *compressWriter) Flush() {
+	if flusher, ok := cw.encoder.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Push keeps HTTP/2 server push working through the middleware.
+func (cw *compressWriter) Push(target string, opts *http.PushOptions) error {
+	if pusher, ok := cw.ResponseWriter.(http.Pusher); ok {
+		return pusher.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+func (cw *compressWriter) Close() error {
+	if cw.encoder != nil {
+		return cw.encoder.Close()
+	}
+	return nil
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/applenick/appletar/render"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	ConfigLocation = "config.json"
+	EnvPrefix      = "MINOTAR"
+)
+
+// Command line flags, which take precedence over everything else.
+var (
+	configFlag    = flag.String("config", ConfigLocation, "JSON file to load the configuration from, or - for stdin")
+	listenFlag    = flag.String("listen", "", "address to listen on")
+	diskCacheFlag = flag.Bool("disk-cache", false, "cache skins on disk")
+)
+
+// MinotarConfig is loaded from config.json. Any field can be overridden by
+// an environment variable named MINOTAR_ followed by its JSON key in upper
+// case, e.g. MINOTAR_DISK_CACHE=true. Command line flags take precedence
+// over environment variables, which take precedence over config.json, which
+// takes precedence over the defaults.
+type MinotarConfig struct {
+	// Address to listen on, defaults to DefaultListenOn
+	Listen string `json:"listen,omitempty"`
+	// Addresses to listen on when listen is unset, each served by its own
+	// server
+	ListenAddrs []string `json:"listen_addrs,omitempty"`
+	// Directory static files are served from, defaults to DefaultStaticLocation
+	StaticDir string `json:"static_dir,omitempty"`
+
+	// Whether rendered skins should be cached at all
+	DiskCache bool `json:"disk_cache"`
+	// Which SkinCache stores the cached skins: "disk" or "redis"
+	CacheBackend string `json:"cache_backend"`
+	// Where the "disk" cache backend keeps skins: "disk" or "s3"
+	StorageType string `json:"storage_type"`
+	S3Bucket    string `json:"s3_bucket"`
+	S3Region    string `json:"s3_region"`
+	S3Prefix    string `json:"s3_prefix"`
+
+	AccessLogging bool `json:"access_logging"`
+	// Whether to trace every step of fetching skins, usually set with
+	// MINOTAR_DEBUG=1
+	Debug bool `json:"debug"`
+	// File to write logs to instead of stderr, rotated once it reaches
+	// LogMaxMB megabytes with LogMaxBackups old files kept
+	LogFile       string `json:"log_file"`
+	LogMaxMB      int    `json:"log_max_mb"`
+	LogMaxBackups int    `json:"log_max_backups"`
+	// CDN to redirect image requests to, rendering them locally when empty.
+	// Redirects are 302s unless CDNPermanentRedirect is set. The CDN must
+	// send CDNSecret in the X-CDN-Secret header when filling its cache
+	CDNBaseURL           string `json:"cdn_base_url"`
+	CDNPermanentRedirect bool   `json:"cdn_permanent_redirect"`
+	CDNSecret            string `json:"cdn_secret"`
+
+	// Whether JSON responses may be brotli compressed, rather than only gzip
+	BrotliEnabled bool `json:"brotli_enabled"`
+
+	// Whether to expose Prometheus metrics at /metrics
+	EnableMetrics bool `json:"enable_metrics"`
+
+	// Base URLs of Mojang's APIs, which offline-mode servers can point at
+	// their own auth service
+	MojangAPIBaseURL     string `json:"mojang_api_base_url"`
+	MojangSessionBaseURL string `json:"mojang_session_base_url"`
+	// How long to wait for Mojang to answer before giving up
+	MojangTimeoutSeconds uint `json:"mojang_timeout_seconds"`
+	// Connection pool for requests to Mojang
+	HTTPMaxIdleConns               int  `json:"http_max_idle_conns"`
+	HTTPIdleConnTimeoutSeconds     uint `json:"http_idle_conn_timeout_seconds"`
+	HTTPTLSHandshakeTimeoutSeconds uint `json:"http_tls_handshake_timeout_seconds"`
+	// Longest we'll wait in total retrying a fetch Mojang rate limited
+	MojangRetryMaxSeconds uint `json:"mojang_retry_max_seconds"`
+	// Consecutive failures to reach Mojang after which we stop trying for
+	// CircuitBreakerCooldownSeconds, 0 disables the circuit breaker
+	CircuitBreakerThreshold       int  `json:"circuit_breaker_threshold"`
+	CircuitBreakerCooldownSeconds uint `json:"circuit_breaker_cooldown_seconds"`
+
+	// Hosts /armor/ may fetch armor textures from
+	ArmorTextureHosts []string `json:"armor_texture_hosts"`
+
+	// Skin served for unknown players instead of char
+	FallbackSkinURL string `json:"fallback_skin_url"`
+
+	// Smallest and largest sizes images may be requested at
+	MinSize uint `json:"min_size"`
+	MaxSize uint `json:"max_size"`
+	// How images are scaled: "nearest", "bilinear" or "lanczos3"
+	ResizeFilter string `json:"resize_filter"`
+	// Whether to keep PNGs rendered at the common power-of-two sizes in the
+	// skin storage, which needs disk_cache with the disk cache_backend
+	TileCacheEnabled bool `json:"tile_cache_enabled"`
+
+	// How long skins, and failures to fetch them, are cached for
+	TTLActualSkinSeconds  uint `json:"ttl_actual_skin_seconds"`
+	TTLFailedFetchSeconds uint `json:"ttl_failed_fetch_seconds"`
+
+	// Number of skins to keep decoded in memory, 0 disables the memory cache
+	MemoryCacheSize int `json:"memory_cache_size"`
+
+	// Requests per second allowed from each IP, 0 disables rate limiting
+	RateLimitRPS   float64 `json:"rate_limit_rps"`
+	RateLimitBurst int     `json:"rate_limit_burst"`
+	// Requests per second allowed for each player, 0 disables the limit
+	PerUserRPS float64 `json:"per_user_rps"`
+	// Whether to believe X-Forwarded-For and X-Real-IP when identifying clients
+	TrustProxy bool `json:"trust_proxy"`
+
+	// Origins browsers may read our images from, "*" allows any
+	CORSAllowedOrigins []string `json:"cors_allowed_origins"`
+
+	// File of usernames, one per line, whose skins are fetched at startup
+	PrefetchList        string `json:"prefetch_list"`
+	PrefetchConcurrency int    `json:"prefetch_concurrency"`
+
+	// Most players a POST /batch may ask for, and how many are fetched at once
+	BatchMaxUsers    int `json:"batch_max_users"`
+	BatchConcurrency int `json:"batch_concurrency"`
+
+	// Whether to serve the JSON API under /v2/
+	VersionedAPI bool `json:"versioned_api"`
+
+	TLS TLSConfig `json:"tls"`
+
+	SecurityHeaders SecurityHeadersConfig `json:"security_headers"`
+
+	// Bearer token for POST /cache/invalidate/, which is disabled when empty
+	CacheInvalidateToken string `json:"cache_invalidate_token"`
+
+	// Secret POST /webhook/skin-change bodies are signed with, which is
+	// disabled when empty
+	WebhookSecret string `json:"webhook_secret"`
+
+	// Bearer token for POST /skin/upload, which is disabled when empty.
+	// Uploads are kept wherever storage_type says
+	SkinUploadToken string `json:"skin_upload_token"`
+
+	// Whether to HTTP/2 push the index page's avatar along with the page
+	PushEnabled bool `json:"push_enabled"`
+	// Whether to generate a robots.txt keeping crawlers away from the images
+	// when the static directory doesn't have one
+	ServeRobotsTxt bool `json:"serve_robots_txt"`
+
+	// How long to wait for in-flight requests when shutting down
+	ShutdownTimeoutSeconds uint `json:"shutdown_timeout_seconds"`
+
+	RedisAddr       string `json:"redis_addr"`
+	RedisTTLSeconds uint   `json:"redis_ttl_seconds"`
+}
+
+var config MinotarConfig
+
+func defaultConfiguration() MinotarConfig {
+	return MinotarConfig{
+		CacheBackend:    "disk",
+		StorageType:     "disk",
+		ResizeFilter:    "nearest",
+		MinSize:         MinSize,
+		MaxSize:         MaxSize,
+		LogMaxMB:        100,
+		LogMaxBackups:   3,
+		RedisAddr:       "localhost:6379",
+		RedisTTLSeconds: TimeoutActualSkin,
+		MemoryCacheSize: 1000,
+		RateLimitBurst:  10,
+
+		TTLActualSkinSeconds:  TimeoutActualSkin,
+		TTLFailedFetchSeconds: TimeoutFailedFetch,
+
+		MojangTimeoutSeconds:  5,
+		MojangRetryMaxSeconds: 10,
+
+		HTTPMaxIdleConns:               100,
+		HTTPIdleConnTimeoutSeconds:     90,
+		HTTPTLSHandshakeTimeoutSeconds: 10,
+
+		CircuitBreakerThreshold:       5,
+		CircuitBreakerCooldownSeconds: 30,
+
+		PrefetchConcurrency: 4,
+
+		BatchMaxUsers:    100,
+		BatchConcurrency: 8,
+
+		ArmorTextureHosts: []string{"textures.minecraft.net"},
+
+		SecurityHeaders: defaultSecurityHeaders(),
+
+		ShutdownTimeoutSeconds: 30,
+
+		ServeRobotsTxt: true,
+	}
+}
+
+func loadConfiguration() (MinotarConfig, error) {
+	cfg := defaultConfiguration()
+
+	location := *configFlag
+	var data []byte
+	var err error
+	if location == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(location)
+	}
+	// With no config file we just run with the defaults, unless one was asked for
+	if err != nil && !(os.IsNotExist(err) && location == ConfigLocation) {
+		return cfg, err
+	} else if err == nil {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&cfg)
+		if err != nil {
+			return cfg, fmt.Errorf("%s: %s", location, err)
+		}
+	}
+
+	err = applyEnvironment(reflect.ValueOf(&cfg).Elem(), EnvPrefix)
+	if err != nil {
+		return cfg, err
+	}
+	applyFlags(&cfg)
+
+	cfg.fillDefaults()
+	return cfg, cfg.validate()
+}
+
+// applyFlags overrides cfg with any command line flags that were given.
+func applyFlags(cfg *MinotarConfig) {
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listen":
+			cfg.Listen = *listenFlag
+		case "disk-cache":
+			cfg.DiskCache = *diskCacheFlag
+		}
+	})
+}
+
+// applyEnvironment overrides the fields of the struct v with any environment
+// variables named after their JSON keys, so redis_addr is set by
+// MINOTAR_REDIS_ADDR and tls.enabled by MINOTAR_TLS_ENABLED. Lists are
+// comma separated.
+func applyEnvironment(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(key)
+		field := v.Field(i)
+
+		if field.Kind() == reflect.Struct {
+			err := applyEnvironment(field, name)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		var err error
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Bool:
+			var b bool
+			b, err = strconv.ParseBool(value)
+			field.SetBool(b)
+		case reflect.Int, reflect.Int64:
+			var n int64
+			n, err = strconv.ParseInt(value, 10, 64)
+			field.SetInt(n)
+		case reflect.Uint, reflect.Uint64:
+			var n uint64
+			n, err = strconv.ParseUint(value, 10, 64)
+			field.SetUint(n)
+		case reflect.Float64:
+			var f float64
+			f, err = strconv.ParseFloat(value, 64)
+			field.SetFloat(f)
+		case reflect.Slice:
+			if field.Type().Elem().Kind() != reflect.String {
+				err = fmt.Errorf("unsupported type %s", field.Type())
+				break
+			}
+			// Lists are given comma separated, e.g. a.com,b.com
+			field.Set(reflect.ValueOf(strings.Split(value, ",")))
+		default:
+			err = fmt.Errorf("unsupported type %s", field.Type())
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// listenAddrs returns the addresses to serve plain HTTP on. A single listen
+// address takes precedence over listen_addrs.
+func (cfg MinotarConfig) listenAddrs() []string {
+	if cfg.Listen != "" {
+		return []string{cfg.Listen}
+	}
+	return cfg.ListenAddrs
+}
+
+// validate checks the configuration makes sense, describing every problem
+// found rather than just the first.
+func (cfg MinotarConfig) validate() error {
+	var problems []string
+
+	for _, addr := range cfg.listenAddrs() {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			problems = append(problems, fmt.Sprintf("listen: %q is not a valid address: %s", addr, err))
+		}
+	}
+	if info, err := os.Stat(cfg.StaticDir); err != nil {
+		problems = append(problems, fmt.Sprintf("static_dir: %s", err))
+	} else if !info.IsDir() {
+		problems = append(problems, fmt.Sprintf("static_dir: %s is not a directory", cfg.StaticDir))
+	}
+
+	if cfg.TLS.Enabled {
+		if _, _, err := net.SplitHostPort(cfg.TLS.Listen); err != nil {
+			problems = append(problems, fmt.Sprintf("tls.listen: %q is not a valid address: %s", cfg.TLS.Listen, err))
+		}
+		if cfg.TLS.CertFile != "" || cfg.TLS.KeyFile != "" {
+			for _, file := range []string{cfg.TLS.CertFile, cfg.TLS.KeyFile} {
+				if _, err := os.Stat(file); err != nil {
+					problems = append(problems, fmt.Sprintf("tls: cert_file and key_file must both be readable: %s", err))
+				}
+			}
+		} else if cfg.TLS.Domain == "" {
+			problems = append(problems, "tls: either cert_file and key_file or domain must be set")
+		}
+	}
+
+	switch cfg.CacheBackend {
+	case "", "disk":
+		switch cfg.StorageType {
+		case "", "disk":
+		case "s3":
+			if cfg.S3Bucket == "" {
+				problems = append(problems, "s3_bucket: must be set when storage_type is \"s3\"")
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("storage_type: must be \"disk\" or \"s3\", not %q", cfg.StorageType))
+		}
+	case "redis":
+		if _, _, err := net.SplitHostPort(cfg.RedisAddr); err != nil {
+			problems = append(problems, fmt.Sprintf("redis_addr: %q is not a valid address: %s", cfg.RedisAddr, err))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("cache_backend: must be \"disk\" or \"redis\", not %q", cfg.CacheBackend))
+	}
+
+	if cfg.CDNBaseURL != "" {
+		if u, err := url.Parse(cfg.CDNBaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("cdn_base_url: %q is not an http or https URL", cfg.CDNBaseURL))
+		}
+		if cfg.CDNSecret == "" {
+			problems = append(problems, "cdn_secret: needed with cdn_base_url, or the CDN's requests would be redirected back to it")
+		}
+	}
+
+	if cfg.MinSize < 1 {
+		problems = append(problems, "min_size: must be at least 1")
+	}
+	if cfg.MaxSize > SizeLimit {
+		problems = append(problems, fmt.Sprintf("max_size: must be at most %d", SizeLimit))
+	}
+	if cfg.MinSize >= cfg.MaxSize {
+		problems = append(problems, "min_size: must be less than max_size")
+	}
+	if _, ok := render.Filters[cfg.ResizeFilter]; !ok {
+		problems = append(problems, fmt.Sprintf("resize_filter: must be \"nearest\", \"bilinear\" or \"lanczos3\", not %q", cfg.ResizeFilter))
+	}
+
+	if cfg.TileCacheEnabled && (!cfg.DiskCache || (cfg.CacheBackend != "" && cfg.CacheBackend != "disk")) {
+		problems = append(problems, "tile_cache_enabled: needs disk_cache with the \"disk\" cache_backend")
+	}
+
+	if cfg.TTLActualSkinSeconds < 1 {
+		problems = append(problems, "ttl_actual_skin_seconds: must be at least 1")
+	}
+
+	if cfg.LogMaxMB < 0 {
+		problems = append(problems, "log_max_mb: must not be negative")
+	}
+	if cfg.LogMaxBackups < 0 {
+		problems = append(problems, "log_max_backups: must not be negative")
+	}
+	if cfg.HTTPMaxIdleConns < 0 {
+		problems = append(problems, "http_max_idle_conns: must not be negative")
+	}
+	if cfg.CircuitBreakerThreshold < 0 {
+		problems = append(problems, "circuit_breaker_threshold: must not be negative")
+	}
+	if cfg.MemoryCacheSize < 0 {
+		problems = append(problems, "memory_cache_size: must not be negative")
+	}
+	if cfg.RateLimitRPS < 0 {
+		problems = append(problems, "rate_limit_rps: must not be negative")
+	}
+	if cfg.PerUserRPS < 0 {
+		problems = append(problems, "per_user_rps: must not be negative")
+	}
+	if cfg.RateLimitBurst < 0 {
+		problems = append(problems, "rate_limit_burst: must not be negative")
+	}
+	if cfg.PrefetchConcurrency < 0 {
+		problems = append(problems, "prefetch_concurrency: must not be negative")
+	}
+	if cfg.BatchMaxUsers < 0 {
+		problems = append(problems, "batch_max_users: must not be negative")
+	}
+	if cfg.BatchConcurrency < 0 {
+		problems = append(problems, "batch_concurrency: must not be negative")
+	}
+	if cfg.PrefetchList != "" {
+		if f, err := os.Open(cfg.PrefetchList); err != nil {
+			problems = append(problems, fmt.Sprintf("prefetch_list: %s", err))
+		} else {
+			f.Close()
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// fillDefaults sets any fields that were left empty to their defaults.
+func (cfg *MinotarConfig) fillDefaults() {
+	if cfg.Listen == "" && len(cfg.ListenAddrs) == 0 {
+		cfg.Listen = DefaultListenOn
+	}
+	if cfg.StaticDir == "" {
+		cfg.StaticDir = DefaultStaticLocation
+	}
+	if cfg.MojangAPIBaseURL == "" {
+		cfg.MojangAPIBaseURL = DefaultMojangAPIBaseURL
+	}
+	if cfg.MojangSessionBaseURL == "" {
+		cfg.MojangSessionBaseURL = DefaultMojangSessionBaseURL
+	}
+	if cfg.TLS.Listen == "" {
+		cfg.TLS.Listen = DefaultTLSListenOn
+	}
+}
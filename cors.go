@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+const (
+	CORSAllowedMethods = "GET, HEAD, OPTIONS"
+	// How long browsers may cache the answer to a preflight request, in seconds
+	CORSMaxAge = 86400
+)
+
+// CORS lets browsers on the allowed origins read our images from scripts
+// and canvases.
+type CORS struct {
+	anyOrigin bool
+	origins   map[string]bool
+}
+
+func NewCORS(origins []string) *CORS {
+	c := &CORS{origins: make(map[string]bool)}
+	for _, origin := range origins {
+		if origin == "*" {
+			c.anyOrigin = true
+		}
+		c.origins[origin] = true
+	}
+	return c
+}
+
+func (c *CORS) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin != "" && (c.anyOrigin || c.origins[origin]) {
+			if c.anyOrigin {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Add("Vary", "Origin")
+			}
+			w.Header().Set("Access-Control-Allow-Methods", CORSAllowedMethods)
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(CORSMaxAge))
+		}
+
+		// Answer preflights ourselves, the handlers only know about GET
+		if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
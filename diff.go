@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"github.com/applenick/appletar/render"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+// diffPage shows how two players' skins differ. With ?mode=mask only the
+// differing pixels are drawn.
+func diffPage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	skinA, statusA := fetchSkin(r.Context(), skinFetcher, vars["usernameA"])
+	skinB, statusB := fetchSkin(r.Context(), skinFetcher, vars["usernameB"])
+
+	img := render.Diff(skinA, skinB, r.URL.Query().Get("mode") == "mask")
+
+	buf := new(bytes.Buffer)
+	err := render.WritePNG(buf, img)
+	if err != nil {
+		serverErrorPage(w, r)
+		return
+	}
+
+	w.Header().Add("Content-Type", "image/png")
+	w.Header().Add("X-Requested", "processed")
+	// The diff is only as good as the worse of the two skins
+	if statusA == CacheStatusFallback {
+		setResultHeaders(w, statusA)
+	} else {
+		setResultHeaders(w, statusB)
+	}
+	writeWithETag(w, r, buf.Bytes())
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// Events buffered for each subscriber before further ones are dropped
+	EventBufferSize = 8
+)
+
+// SkinEvent tells subscribers that something happened to a player's skin.
+type SkinEvent struct {
+	Event    string    `json:"event"`
+	Username string    `json:"username"`
+	At       time.Time `json:"at"`
+}
+
+// EventBroker passes SkinEvents to everyone subscribed to that player.
+type EventBroker struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan SkinEvent]bool
+}
+
+var skinEvents = NewEventBroker()
+
+func NewEventBroker() *EventBroker {
+	return &EventBroker{subscribers: make(map[string]map[chan SkinEvent]bool)}
+}
+
+func (b *EventBroker) Subscribe(username string) chan SkinEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan SkinEvent, EventBufferSize)
+	if b.subscribers[username] == nil {
+		b.subscribers[username] = make(map[chan SkinEvent]bool)
+	}
+	b.subscribers[username][ch] = true
+	return ch
+}
+
+func (b *EventBroker) Unsubscribe(username string, ch chan SkinEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.subscribers[username], ch)
+	if len(b.subscribers[username]) == 0 {
+		delete(b.subscribers, username)
+	}
+}
+
+// Publish sends event to the player's subscribers, skipping any who are too
+// far behind rather than waiting for them.
+func (b *EventBroker) Publish(event SkinEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers[event.Username] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+func publishInvalidated(username string) {
+	skinEvents.Publish(SkinEvent{Event: "invalidated", Username: username, At: time.Now().UTC()})
+}
+
+// eventsPage streams the player's SkinEvents as server-sent events until
+// the client goes away.
+func eventsPage(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		serverErrorPage(w, r)
+		return
+	}
+
+	_, username := normalizeIdentifier(mux.Vars(r)["username"])
+	events := skinEvents.Subscribe(username)
+	defer skinEvents.Unsubscribe(username, events)
+
+	w.Header().Add("Content-Type", "text/event-stream")
+	w.Header().Add("Cache-Control", "no-cache")
+	w.Header().Add("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-events:
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/applenick/appletar/render"
+	"github.com/applenick/minecraft"
+	"image"
+	"log"
+	"time"
+)
+
+// A SkinFetcher looks up the skin for a username or UUID from wherever skins
+// ultimately come from, bypassing any caches.
+type SkinFetcher interface {
+	Fetch(ctx context.Context, username string) (minecraft.Skin, error)
+}
+
+// MojangFetcher fetches skins from Mojang's servers.
+type MojangFetcher struct{}
+
+var skinFetcher SkinFetcher = MojangFetcher{}
+
+// fetchWithRetry fetches a skin, waiting and trying again whenever Mojang
+// rate limits us until config.MojangRetryMaxSeconds have been spent waiting.
+func fetchWithRetry(ctx context.Context, fetcher SkinFetcher, username string) (minecraft.Skin, error) {
+	budget := time.Duration(config.MojangRetryMaxSeconds) * time.Second
+	for {
+		skin, err := fetcher.Fetch(ctx, username)
+		rateLimited, ok := err.(RateLimitedError)
+		if !ok || rateLimited.RetryAfter > budget {
+			return skin, err
+		}
+
+		log.Printf("Rate limited fetching %s, retrying in %s", username, rateLimited.RetryAfter)
+		select {
+		case <-time.After(rateLimited.RetryAfter):
+		case <-ctx.Done():
+			return minecraft.Skin{}, ctx.Err()
+		}
+		budget -= rateLimited.RetryAfter
+	}
+}
+
+func (MojangFetcher) Fetch(ctx context.Context, username string) (minecraft.Skin, error) {
+	if isUUID, uuid := normalizeIdentifier(username); isUUID {
+		return fetchSkinForUUID(ctx, uuid)
+	}
+
+	// Going through mojangGet rather than the minecraft package means the
+	// requests are abandoned along with ctx
+	uuid, err := fetchUUID(ctx, username)
+	if err != nil {
+		return minecraft.Skin{}, err
+	}
+	return fetchSkinForUUID(ctx, uuid)
+}
+
+// validateSkinImage checks img looks like a skin, catching the error pages
+// and truncated downloads Mojang occasionally serves as a success.
+func validateSkinImage(img image.Image) error {
+	if img == nil {
+		return errors.New("Skin has no image")
+	}
+	if !render.ValidSkinSize(img) {
+		size := img.Bounds().Size()
+		return fmt.Errorf("Skin is %dx%d, not 64x32 or 64x64", size.X, size.Y)
+	}
+	switch img.(type) {
+	case *image.NRGBA, *image.RGBA:
+	default:
+		return fmt.Errorf("Skin is a %T, not NRGBA or RGBA", img)
+	}
+
+	bounds := img.Bounds()
+	first := img.At(bounds.Min.X, bounds.Min.Y)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if img.At(x, y) != first {
+				return nil
+			}
+		}
+	}
+	return errors.New("Skin is a single colour")
+}
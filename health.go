@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	MojangCheckInterval = 30 * time.Second
+	MojangCheckUsername = "Notch"
+)
+
+type HealthStatus struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// mojangCheck caches whether Mojang was reachable so that readiness probes
+// don't hit their API every few seconds.
+type mojangCheck struct {
+	mu        sync.Mutex
+	checkedAt time.Time
+	err       error
+}
+
+var mojangReachable mojangCheck
+
+func (c *mojangCheck) Check() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.checkedAt) > MojangCheckInterval {
+		_, c.err = checkMojang()
+		c.checkedAt = time.Now()
+	}
+	return c.err
+}
+
+// checkMojang makes a cheap request to Mojang's API, returning how long it
+// took to answer.
+func checkMojang() (time.Duration, error) {
+	start := time.Now()
+	resp, err := mojangClient.Get(usernameLookupURL(MojangCheckUsername))
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return 0, fmt.Errorf("Mojang returned %s", resp.Status)
+	}
+	return time.Since(start), nil
+}
+
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".ready-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+func writeHealth(w http.ResponseWriter, status HealthStatus) {
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Add("Cache-Control", "no-cache")
+	if status.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
+
+// healthPage reports that the process is up, for liveness probes.
+func healthPage(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, HealthStatus{Status: "ok"})
+}
+
+type MojangHealth struct {
+	Reachable bool   `json:"reachable"`
+	LatencyMs *int64 `json:"latency_ms,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// mojangHealthPage checks Mojang's API right now, unlike /health and
+// /ready, for monitors that want to know whether Mojang is up.
+func mojangHealthPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Add("Cache-Control", "no-cache")
+
+	latency, err := checkMojang()
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(MojangHealth{Error: err.Error()})
+		return
+	}
+	ms := int64(latency / time.Millisecond)
+	json.NewEncoder(w).Encode(MojangHealth{Reachable: true, LatencyMs: &ms})
+}
+
+// pingPage answers pong, for load balancers. With ?check_mojang=1 it instead
+// reports how long Mojang's session server took to answer, so monitors can
+// tell us being down apart from Mojang being down.
+func pingPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Cache-Control", "no-cache")
+	check := r.URL.Query().Get("check_mojang")
+	if check != "1" && check != "true" {
+		w.Header().Add("Content-Type", "text/plain")
+		fmt.Fprint(w, "pong")
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	start := time.Now()
+	resp, err := mojangClient.Head(config.MojangSessionBaseURL)
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"mojang_error": err.Error()})
+		return
+	}
+	resp.Body.Close()
+
+	json.NewEncoder(w).Encode(map[string]int64{"mojang_latency_ms": timeBetween(start, time.Now())})
+}
+
+// readyPage reports whether we can actually serve skins, for readiness
+// probes.
+func readyPage(w http.ResponseWriter, r *http.Request) {
+	status := HealthStatus{Status: "ok", Checks: make(map[string]string)}
+	check := func(name string, err error) {
+		if err != nil {
+			status.Status = "unavailable"
+			status.Checks[name] = err.Error()
+		} else {
+			status.Checks[name] = "ok"
+		}
+	}
+
+	if dir, ok := localCacheDir(skinCache); ok {
+		check("skin_cache", checkWritable(dir))
+	}
+	check("mojang", mojangReachable.Check())
+
+	writeHealth(w, status)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// Minutes of timings kept by LatencyHistogram
+	HistogramMinutes = 60
+)
+
+// HistogramBuckets are the upper bounds, in milliseconds, of the buckets
+// timings are counted in. Anything slower is counted in the last.
+var HistogramBuckets = []int64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000}
+
+// HistogramTimings are the timings of each request LatencyHistogram counts.
+var HistogramTimings = []string{"fetch_ms", "process_ms", "resize_ms", "total_ms"}
+
+// LatencyHistogram counts request timings into fixed buckets, one set per
+// minute for the last HistogramMinutes minutes.
+type LatencyHistogram struct {
+	mu      sync.Mutex
+	minutes [HistogramMinutes]histogramMinute
+}
+
+type histogramMinute struct {
+	minute int64 // since the epoch
+	counts [][]uint64
+}
+
+type HistogramBucket struct {
+	BucketMs int64  `json:"bucket_ms"`
+	Count    uint64 `json:"count"`
+}
+
+var latencyHistogram LatencyHistogram
+
+// Record counts the timings of a request that started at timeReqStart.
+func (h *LatencyHistogram) Record(entry *AccessLogEntry, timeReqStart time.Time) {
+	now := time.Now()
+	timings := []int64{entry.FetchMs, entry.ProcessMs, entry.ResizeMs, timeBetween(timeReqStart, now)}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	minute := now.Unix() / 60
+	m := &h.minutes[minute%HistogramMinutes]
+	if m.minute != minute || m.counts == nil {
+		// This slot last held an hour ago
+		m.minute = minute
+		m.counts = make([][]uint64, len(HistogramTimings))
+		for i := range m.counts {
+			m.counts[i] = make([]uint64, len(HistogramBuckets))
+		}
+	}
+	for i, ms := range timings {
+		m.counts[i][histogramBucket(ms)]++
+	}
+}
+
+func histogramBucket(ms int64) int {
+	for i, bound := range HistogramBuckets {
+		if ms <= bound {
+			return i
+		}
+	}
+	return len(HistogramBuckets) - 1
+}
+
+// Buckets totals the counts of the named timing over the last minutes.
+func (h *LatencyHistogram) Buckets(timing string, minutes int) ([]HistogramBucket, error) {
+	index := -1
+	for i, name := range HistogramTimings {
+		if name == timing {
+			index = i
+		}
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("Unknown bucket %q", timing)
+	}
+
+	buckets := make([]HistogramBucket, len(HistogramBuckets))
+	for i, bound := range HistogramBuckets {
+		buckets[i].BucketMs = bound
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now().Unix() / 60
+	for _, m := range h.minutes {
+		if m.counts == nil || now-m.minute >= int64(minutes) {
+			continue
+		}
+		for i, count := range m.counts[index] {
+			buckets[i].Count += count
+		}
+	}
+	return buckets, nil
+}
+
+// histogramPage reports how request timings were distributed over the last
+// ?minutes= minutes. ?bucket= picks the timing, defaulting to total_ms.
+func histogramPage(w http.ResponseWriter, r *http.Request) {
+	minutes, err := strconv.Atoi(r.URL.Query().Get("minutes"))
+	if err != nil || minutes < 1 {
+		minutes = 5
+	} else if minutes > HistogramMinutes {
+		minutes = HistogramMinutes
+	}
+	timing := r.URL.Query().Get("bucket")
+	if timing == "" {
+		timing = "total_ms"
+	}
+
+	buckets, err := latencyHistogram.Buckets(timing, minutes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Add("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(buckets)
+}
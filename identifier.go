@@ -0,0 +1,27 @@
+package main
+
+import (
+	"github.com/applenick/minecraft"
+	"regexp"
+	"strings"
+)
+
+const (
+	ValidUUIDRegex = `[0-9a-fA-F]{8}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{12}`
+
+	// Matches either a UUID or a username in a route
+	ValidIdentifierRegex = `(?:` + ValidUUIDRegex + `|` + minecraft.ValidUsernameRegex + `)`
+)
+
+var uuidRegexp = regexp.MustCompile(`^` + ValidUUIDRegex + `$`)
+
+// normalizeIdentifier works out whether s is a UUID or a username. UUIDs are
+// returned without hyphens, the form Mojang's APIs expect. Both are
+// lowercased, as neither is case sensitive, so that everything keyed by
+// player agrees however the name was typed.
+func normalizeIdentifier(s string) (isUUID bool, val string) {
+	if uuidRegexp.MatchString(s) {
+		return true, strings.ToLower(strings.Replace(s, "-", "", -1))
+	}
+	return false, strings.ToLower(s)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/subtle"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// invalidatePage forgets a player's cached skin so the next request fetches
+// it afresh. It requires the configured token as a bearer token, and doesn't
+// exist at all if no token is configured.
+func invalidatePage(w http.ResponseWriter, r *http.Request) {
+	if config.CacheInvalidateToken == "" {
+		notFoundPage(w, r)
+		return
+	}
+
+	if !checkBearerToken(w, r, config.CacheInvalidateToken) {
+		return
+	}
+
+	_, username := normalizeIdentifier(mux.Vars(r)["username"])
+	err := invalidateSkin(username)
+	if err != nil {
+		log.Printf("Unable to invalidate skin for %s: %s", username, err)
+		serverErrorPage(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkBearerToken reports whether r carries token as its bearer token,
+// answering 401 Unauthorized if not.
+func checkBearerToken(w http.ResponseWriter, r *http.Request, token string) bool {
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		w.Header().Add("WWW-Authenticate", "Bearer")
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func invalidateSkin(username string) error {
+	publishInvalidated(username)
+	if memoryCache != nil {
+		memoryCache.Delete(username)
+	}
+	if tileCache != nil {
+		if err := tileCache.Delete(username); err != nil {
+			return err
+		}
+	}
+	if skinCache != nil {
+		return skinCache.Delete(username)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"gopkg.in/natefinch/lumberjack.v2"
+	"io"
+	"log"
+	"log/slog"
+)
+
+// debugLog traces what each request does step by step. It discards
+// everything unless debug logging is enabled, which is cheap enough to leave
+// the calls in production.
+var debugLog = slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+// setupLogging sends both the error and access logs to the configured log
+// file, rotating it once it grows past log_max_mb. Without a log file they
+// stay on stderr and stdout.
+func setupLogging(cfg MinotarConfig) {
+	// Debug lines go wherever the error log goes
+	defer func() {
+		if cfg.Debug {
+			debugLog = slog.New(slog.NewTextHandler(log.Writer(), &slog.HandlerOptions{Level: slog.LevelDebug}))
+		}
+	}()
+
+	if cfg.LogFile == "" {
+		return
+	}
+
+	out := &lumberjack.Logger{
+		Filename:   cfg.LogFile,
+		MaxSize:    cfg.LogMaxMB,
+		MaxBackups: cfg.LogMaxBackups,
+	}
+	log.SetOutput(out)
+	accessLogger.SetOutput(out)
+}
+
+// debug writes a debug line, tagged with the ID of the request ctx belongs to.
+func debug(ctx context.Context, msg string, args ...interface{}) {
+	if !debugLog.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	debugLog.DebugContext(ctx, msg, append([]interface{}{"request_id", RequestIDFromContext(ctx)}, args...)...)
+}
@@ -1,37 +1,67 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
 	"fmt"
-	"github.com/gorilla/mux"
+	"github.com/applenick/appletar/render"
 	"github.com/applenick/minecraft"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/sync/singleflight"
 	"image"
+	"image/color"
 	"io"
 	"log"
 	"net/http"
 	"os"
 	"path"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
 	DefaultSize = uint(180)
-	MaxSize     = uint(300)
-	MinSize     = uint(8)
+	MaxSize     = uint(300) // default for max_size
+	MinSize     = uint(8)   // default for min_size
+	// Largest max_size that may be configured
+	SizeLimit = uint(1024)
+
+	DefaultFrames = 8
+	MaxFrames     = 24
+	MinFrames     = 4
 
-	StaticLocation = "www"
-	SkinCache
+	MaxPadding = 32
 
-	ListenOn = ":80"
+	DefaultStaticLocation = "www"
+	SkinCacheLocation     = "skins"
 
+	DefaultListenOn = ":80"
+
+	// Defaults for ttl_actual_skin_seconds and ttl_failed_fetch_seconds
 	Minutes            uint = 60
 	Hours                   = 60 * Minutes
 	Days                    = 24 * Hours
 	TimeoutActualSkin       = 2 * Days
 	TimeoutFailedFetch      = 15 * Minutes
 
+	// Thumbnails are favicon sized and cached by browsers for a week
+	ThumbnailSize   = uint(16)
+	ThumbnailMaxAge = 7 * Days
+
 	MinotarVersion = "1.2"
+
+	IndexAvatarPath = "/avatar/default/180"
 )
 
 func serveStatic(w http.ResponseWriter, r *http.Request, inpath string) error {
@@ -42,7 +72,7 @@ func serveStatic(w http.ResponseWriter, r *http.Request, inpath string) error {
 		inpath = "/" + inpath
 		r.URL.Path = inpath
 	}
-	path := StaticLocation + inpath
+	path := config.StaticDir + inpath
 
 	f, err := os.Open(path)
 	if err != nil {
@@ -67,6 +97,15 @@ func serveAssetPage(w http.ResponseWriter, r *http.Request) {
 }
 
 func indexPage(w http.ResponseWriter, r *http.Request) {
+	if pusher, ok := w.(http.Pusher); ok && config.PushEnabled {
+		// Start sending the avatar on the index page before it is asked for
+		err := pusher.Push(IndexAvatarPath, nil)
+		if err != nil && err != http.ErrNotSupported {
+			log.Println("Unable to push index avatar:", err)
+		}
+	}
+
+	allowPageResources(w)
 	err := serveStatic(w, r, "index.html")
 	if err != nil {
 		notFoundPage(w, r)
@@ -76,9 +115,10 @@ func indexPage(w http.ResponseWriter, r *http.Request) {
 type NotFoundHandler struct{}
 
 func (h NotFoundHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	allowPageResources(w)
 	w.WriteHeader(404)
 
-	f, err := os.Open("static/404.html")
+	f, err := os.Open(path.Join(config.StaticDir, "404.html"))
 	if err != nil {
 		fmt.Fprintf(w, "404 file not found")
 		return
@@ -102,18 +142,158 @@ func rationalizeSize(inp string) uint {
 	out := uint(out64)
 	if err != nil {
 		return DefaultSize
-	} else if out > MaxSize {
-		return MaxSize
-	} else if out < MinSize {
-		return MinSize
+	} else if out > config.MaxSize {
+		return config.MaxSize
+	} else if out < config.MinSize {
+		return config.MinSize
 	}
 	return out
 }
 
+// rationalizeScale accepts ?scale= as 2, 3 or 4, optionally followed by an
+// x, returning 1 for anything else.
+func rationalizeScale(inp string) uint {
+	switch strings.TrimSuffix(strings.ToLower(inp), "x") {
+	case "2":
+		return 2
+	case "3":
+		return 3
+	case "4":
+		return 4
+	}
+	return 1
+}
+
+func rationalizePadding(inp string) int {
+	padding, err := strconv.Atoi(inp)
+	if err != nil || padding < 0 {
+		return 0
+	} else if padding > MaxPadding {
+		return MaxPadding
+	}
+	return padding
+}
+
+// registerRendererRoutes serves every render type added with
+// render.RegisterRenderer. It must be called after the built-in routes are
+// added, so that it can refuse to shadow them.
+func registerRendererRoutes(r *mux.Router) {
+	for _, name := range render.RegisteredRenderers() {
+		probe, _ := http.NewRequest("GET", "/"+name+"/char/"+strconv.Itoa(int(DefaultSize)), nil)
+		var match mux.RouteMatch
+		if r.Match(probe, &match) && match.MatchErr == nil {
+			log.Fatalf("Renderer %q clashes with an existing route", name)
+		}
+
+		renderer, _ := render.Renderer(name)
+		page := CDNMiddleware(http.HandlerFunc(fetchImageProcessThen(name, renderer)))
+		r.Handle("/"+name+"/{username:"+ValidIdentifierRegex+"}{extension:(.png)?}", page)
+		r.Handle("/"+name+"/{username:"+ValidIdentifierRegex+"}/{size:[0-9]+}{extension:(.png)?}", page)
+		log.Printf("Serving %s renders at /%s/", name, name)
+	}
+}
+
+// rationalizeBackground parses a ?bg= colour of 3 or 6 hex digits, returning
+// false if there isn't a valid one.
+func rationalizeBackground(inp string) (color.RGBA, bool) {
+	inp = strings.TrimPrefix(inp, "#")
+	if len(inp) == 3 {
+		inp = string([]byte{inp[0], inp[0], inp[1], inp[1], inp[2], inp[2]})
+	}
+	if len(inp) != 6 {
+		return color.RGBA{}, false
+	}
+
+	rgb, err := hex.DecodeString(inp)
+	if err != nil {
+		return color.RGBA{}, false
+	}
+	return color.RGBA{rgb[0], rgb[1], rgb[2], 255}, true
+}
+
+// negotiateFormat picks the output format for an image from the ?format=
+// query parameter, falling back to the Accept header and then PNG.
+func negotiateFormat(r *http.Request) string {
+	switch strings.ToLower(r.URL.Query().Get("format")) {
+	case "png":
+		return render.FormatPNG
+	case "jpeg", "jpg":
+		return render.FormatJPEG
+	case "webp":
+		return render.FormatWebP
+	case "avif":
+		return render.FormatAVIF
+	}
+
+	// AVIF is preferred as it is the smaller of the two
+	accept := r.Header.Get("Accept")
+	if strings.Contains(accept, "image/avif") {
+		return render.FormatAVIF
+	} else if strings.Contains(accept, "image/webp") {
+		return render.FormatWebP
+	} else if strings.Contains(accept, "image/jpeg") && !strings.Contains(accept, "image/png") {
+		return render.FormatJPEG
+	}
+	return render.FormatPNG
+}
+
+func rationalizeQuality(inp string, format string) int {
+	quality, err := strconv.Atoi(inp)
+	if err != nil || quality < 1 || quality > 100 {
+		switch format {
+		case render.FormatWebP:
+			return render.DefaultWebPQuality
+		case render.FormatAVIF:
+			return render.DefaultAVIFQuality
+		}
+		return render.DefaultQuality
+	}
+	return quality
+}
+
 func addCacheTimeoutHeader(w http.ResponseWriter, timeout uint) {
 	w.Header().Add("Cache-Control", fmt.Sprintf("max-age=%d", timeout))
 }
 
+// setResultHeaders reports whether the player's own skin was used, caching
+// the response for less time when it wasn't.
+func setResultHeaders(w http.ResponseWriter, cacheStatus CacheStatus) {
+	if cacheStatus != CacheStatusFallback {
+		w.Header().Add("X-Result", "ok")
+		addCacheTimeoutHeader(w, config.TTLActualSkinSeconds)
+	} else {
+		w.Header().Add("X-Result", "failed")
+		addCacheTimeoutHeader(w, config.TTLFailedFetchSeconds)
+	}
+}
+
+// writeWithETag sends data tagged with a hash of its contents, or just a 304
+// if the client already has a copy with the same tag. It returns the status
+// code sent.
+func writeWithETag(w http.ResponseWriter, r *http.Request, data []byte) int {
+	etag := fmt.Sprintf("\"%x\"", md5.Sum(data))
+	w.Header().Set("ETag", etag)
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return http.StatusNotModified
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
+	return http.StatusOK
+}
+
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
 func timeBetween(timeA time.Time, timeB time.Time) int64 {
 	// millis between two timestamps
 
@@ -123,7 +303,7 @@ func timeBetween(timeA time.Time, timeB time.Time) int64 {
 	return timeB.Sub(timeA).Nanoseconds() / 1000000
 }
 
-func fetchImageProcessThen(callback func(minecraft.Skin) (image.Image, error)) func(w http.ResponseWriter, r *http.Request) {
+func fetchImageProcessThen(endpoint string, callback func(minecraft.Skin) (image.Image, error)) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		timeReqStart := time.Now()
 
@@ -131,110 +311,772 @@ func fetchImageProcessThen(callback func(minecraft.Skin) (image.Image, error)) f
 
 		username := vars["username"]
 		size := rationalizeSize(vars["size"])
-		ok := true
 
-		var skin minecraft.Skin
-		var err error
+		logEntry := AccessLogEntry{IP: RealIP(r, config.TrustProxy), Username: username, Endpoint: endpoint, RequestID: RequestIDFromContext(r.Context())}
+		defer logAccess(&logEntry, timeReqStart)
+		defer recordMetrics(&logEntry)
+		defer latencyHistogram.Record(&logEntry, timeReqStart)
 
-		skin = fetchSkin(username)
+		// Plain PNGs at the common sizes can come straight from the tile cache
+		useTile := tileCache != nil && isTileSize(size) && r.URL.RawQuery == "" && vars["scale"] == "" &&
+			vars["extension"] != ".webp" && negotiateFormat(r) == render.FormatPNG
+		if useTile {
+			if data, err := tileCache.Get(username, size, endpoint); err == nil {
+				logEntry.CacheHit = true
+				atomic.AddUint64(&stats.totalRequests, 1)
+				w.Header().Add("X-Cache-Status", string(CacheStatusTile))
+				w.Header().Add("Content-Type", render.FormatContentTypes[render.FormatPNG])
+				w.Header().Add("Vary", "Accept")
+				w.Header().Add("X-Requested", "processed")
+				setResultHeaders(w, CacheStatusTile)
+				logEntry.StatusCode = writeWithETag(w, r, data)
+				return
+			}
+		}
+
+		skin, cacheStatus := fetchSkin(r.Context(), skinFetcher, username)
+		logEntry.CacheHit = cacheStatus.IsHit()
+		w.Header().Add("X-Cache-Status", string(cacheStatus))
+		ok := cacheStatus != CacheStatusFallback
 
 		timeFetch := time.Now()
+		logEntry.FetchMs = timeBetween(timeReqStart, timeFetch)
+		atomic.AddUint64(&stats.totalRequests, 1)
+		stats.RecordFetch(logEntry.FetchMs)
+
+		process := callback
+		if endpoint == "helm" && r.URL.Query().Get("helm") == "0" {
+			// ?helm=0 strips the outer layer, leaving just the head
+			process = render.Head
+		}
 
-		img, err := callback(skin)
+		img, err := process(skin)
 		if err != nil {
+			logEntry.StatusCode = http.StatusInternalServerError
 			serverErrorPage(w, r)
 			return
 		}
 		timeProcess := time.Now()
+		logEntry.ProcessMs = timeBetween(timeFetch, timeProcess)
 
-		imgResized := Resize(size, size, img)
+		// ?scale= and @2x URLs multiply the size even past max_size, keeping
+		// every pixel of the skin square
+		filter := config.ResizeFilter
+		scale := rationalizeScale(r.URL.Query().Get("scale"))
+		if vars["scale"] != "" {
+			scale = rationalizeScale(vars["scale"])
+			w.Header().Add("Vary", "DPR")
+		}
+		if scale > 1 {
+			size *= scale
+			filter = "nearest"
+		}
+		imgResized := render.Resize(size, 0, img, filter)
+		padding := rationalizePadding(r.URL.Query().Get("padding"))
+		if padding > 0 {
+			imgResized = render.Pad(imgResized, padding)
+		}
+		if bg, ok := rationalizeBackground(r.URL.Query().Get("bg")); ok {
+			imgResized = render.Background(imgResized, bg)
+		}
 		timeResize := time.Now()
+		logEntry.ResizeMs = timeBetween(timeProcess, timeResize)
 
-		w.Header().Add("Content-Type", "image/png")
-		w.Header().Add("X-Requested", "processed")
-		var timeout uint
-		if ok {
-			w.Header().Add("X-Result", "ok")
-			timeout = TimeoutActualSkin
-		} else {
-			w.Header().Add("X-Result", "failed")
-			timeout = TimeoutFailedFetch
+		format := negotiateFormat(r)
+		if vars["extension"] == ".webp" {
+			format = render.FormatWebP
+		} else if padding > 0 && format == render.FormatJPEG {
+			// The padding has to stay transparent
+			format = render.FormatPNG
 		}
+		w.Header().Add("Content-Type", render.FormatContentTypes[format])
+		w.Header().Add("Vary", "Accept")
+		w.Header().Add("X-Requested", "processed")
+		setResultHeaders(w, cacheStatus)
 		w.Header().Add("X-Timing", fmt.Sprintf("%d+%d+%d=%dms", timeBetween(timeReqStart, timeFetch), timeBetween(timeFetch, timeProcess), timeBetween(timeProcess, timeResize), timeBetween(timeReqStart, timeResize)))
-		addCacheTimeoutHeader(w, timeout)
-		WritePNG(w, imgResized)
+
+		buf := new(bytes.Buffer)
+		err = render.WriteImage(buf, imgResized, format, rationalizeQuality(r.URL.Query().Get("quality"), format))
+		if err != nil {
+			logEntry.StatusCode = http.StatusInternalServerError
+			serverErrorPage(w, r)
+			return
+		}
+		if useTile && ok {
+			if err := tileCache.Save(username, size, endpoint, buf.Bytes()); err != nil {
+				log.Printf("Unable to save %s tile for %s: %s", endpoint, username, err)
+			}
+		}
+		logEntry.StatusCode = writeWithETag(w, r, buf.Bytes())
+	}
+}
+func rationalizeFrames(inp string) int {
+	frames, err := strconv.Atoi(inp)
+	if err != nil {
+		return DefaultFrames
+	} else if frames > MaxFrames {
+		return MaxFrames
+	} else if frames < MinFrames {
+		return MinFrames
+	}
+	return frames
+}
+
+// rotatingHeadPage serves an animated GIF of the player's head turning
+// round, in ?frames= steps.
+func rotatingHeadPage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	size := rationalizeSize(vars["size"])
+
+	skin, cacheStatus := fetchSkin(r.Context(), skinFetcher, vars["username"])
+	w.Header().Add("X-Cache-Status", string(cacheStatus))
+
+	frames, delays, err := render.RotatingHead(skin, rationalizeFrames(r.URL.Query().Get("frames")))
+	if err != nil {
+		serverErrorPage(w, r)
+		return
+	}
+	for i, frame := range frames {
+		frames[i] = render.Resize(size, 0, frame, config.ResizeFilter)
+	}
+
+	buf := new(bytes.Buffer)
+	err = render.WriteGIF(buf, frames, delays)
+	if err != nil {
+		serverErrorPage(w, r)
+		return
+	}
+
+	w.Header().Add("Content-Type", "image/gif")
+	w.Header().Add("X-Requested", "processed")
+	setResultHeaders(w, cacheStatus)
+	writeWithETag(w, r, buf.Bytes())
+}
+
+// requestedSize is the avatar size asked for by the {size} route variable, or
+// by ?size= on routes without one.
+func requestedSize(r *http.Request) uint {
+	size := mux.Vars(r)["size"]
+	if size == "" {
+		size = r.URL.Query().Get("size")
+	}
+	return rationalizeSize(size)
+}
+
+// renderAvatarPNG renders the avatar of the requested player at the
+// requested size, for endpoints that wrap the PNG in something else.
+func renderAvatarPNG(w http.ResponseWriter, r *http.Request) ([]byte, CacheStatus, error) {
+	return renderAvatarPNGAt(w, r, requestedSize(r))
+}
+
+// renderAvatarPNGAt renders the avatar of the requested player at size.
+func renderAvatarPNGAt(w http.ResponseWriter, r *http.Request, size uint) ([]byte, CacheStatus, error) {
+	skin, cacheStatus := fetchSkin(r.Context(), skinFetcher, mux.Vars(r)["username"])
+	w.Header().Add("X-Cache-Status", string(cacheStatus))
+
+	img, err := render.Head(skin)
+	if err != nil {
+		return nil, cacheStatus, err
+	}
+	buf := new(bytes.Buffer)
+	err = render.WritePNG(buf, render.Resize(size, 0, img, config.ResizeFilter))
+	return buf.Bytes(), cacheStatus, err
+}
+
+// svgAvatarPage serves the avatar as an SVG wrapping the PNG, which browsers
+// scale without blurring its pixels.
+func svgAvatarPage(w http.ResponseWriter, r *http.Request) {
+	size := requestedSize(r)
+	data, cacheStatus, err := renderAvatarPNG(w, r)
+	if err != nil {
+		serverErrorPage(w, r)
+		return
+	}
+
+	svg := new(bytes.Buffer)
+	fmt.Fprintf(svg, `<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="%[1]d" viewBox="0 0 %[1]d %[1]d">`, size)
+	fmt.Fprintf(svg, `<image width="%[1]d" height="%[1]d" style="image-rendering:pixelated" href="data:image/png;base64,%[2]s"/>`, size, base64.StdEncoding.EncodeToString(data))
+	fmt.Fprint(svg, `</svg>`)
+
+	w.Header().Add("Content-Type", "image/svg+xml")
+	w.Header().Add("X-Requested", "processed")
+	setResultHeaders(w, cacheStatus)
+	writeWithETag(w, r, svg.Bytes())
+}
+
+// cssAvatarPage serves a CSS rule with the avatar embedded as its
+// background, saving pages a request for the image itself.
+func cssAvatarPage(w http.ResponseWriter, r *http.Request) {
+	size := requestedSize(r)
+	data, cacheStatus, err := renderAvatarPNG(w, r)
+	if err != nil {
+		serverErrorPage(w, r)
+		return
 	}
+
+	css := new(bytes.Buffer)
+	fmt.Fprintf(css, ".minotar-%s { background-image: url(\"data:image/png;base64,%s\"); width: %dpx; height: %dpx; }\n", mux.Vars(r)["username"], base64.StdEncoding.EncodeToString(data), size, size)
+
+	w.Header().Add("Content-Type", "text/css")
+	w.Header().Add("X-Requested", "processed")
+	setResultHeaders(w, cacheStatus)
+	writeWithETag(w, r, css.Bytes())
+}
+
+// thumbnailPage serves a favicon sized avatar, advertised as an icon so
+// browsers pick it up when the URL is used as one.
+func thumbnailPage(w http.ResponseWriter, r *http.Request) {
+	data, cacheStatus, err := renderAvatarPNGAt(w, r, ThumbnailSize)
+	if err != nil {
+		serverErrorPage(w, r)
+		return
+	}
+
+	w.Header().Add("Content-Type", "image/png")
+	// Sent whatever security_headers says, as icons are often MIME sniffed
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Add("Link", fmt.Sprintf("<%s>; rel=\"icon\"", r.URL.Path))
+	w.Header().Add("X-Requested", "processed")
+	if cacheStatus == CacheStatusFallback {
+		setResultHeaders(w, cacheStatus)
+	} else {
+		// Unlike other images, players' own thumbnails are kept for a week
+		w.Header().Add("X-Result", "ok")
+		addCacheTimeoutHeader(w, ThumbnailMaxAge)
+	}
+	writeWithETag(w, r, data)
 }
+
 func skinPage(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
 	username := vars["username"]
 
-	skin := fetchSkin(username)
+	skin, _ := fetchSkin(r.Context(), skinFetcher, username)
+	if !render.ValidSkinSize(skin.Image) {
+		serverErrorPage(w, r)
+		return
+	}
 
+	if w.Header().Get("Content-Disposition") == "" {
+		w.Header().Add("Content-Disposition", fmt.Sprintf("inline; filename=\"%s.png\"", username))
+	}
 	w.Header().Add("Content-Type", "image/png")
 	w.Header().Add("X-Requested", "skin")
 	w.Header().Add("X-Result", "ok")
 
-	WritePNG(w, skin.Image)
+	buf := new(bytes.Buffer)
+	err := render.WritePNG(buf, skin.Image)
+	if err != nil {
+		serverErrorPage(w, r)
+		return
+	}
+	w.Header().Add("Content-Length", strconv.Itoa(buf.Len()))
+
+	// HEAD lets clients check a skin exists without downloading it
+	if r.Method != "HEAD" {
+		w.Write(buf.Bytes())
+	}
+}
+
+type SkinMeta struct {
+	Username  string    `json:"username"`
+	Model     string    `json:"model"`
+	SkinURL   string    `json:"skin_url"`
+	FetchedAt time.Time `json:"fetched_at"`
 }
+
+func skinMetaPage(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["username"]
+
+	skin, _ := fetchSkin(r.Context(), skinFetcher, username)
+	meta := SkinMeta{
+		Username:  username,
+		Model:     render.DetectModel(skin),
+		FetchedAt: time.Now().UTC(),
+	}
+
+	// The skin URL is only known to the session server, so this is best effort
+	textures, err := fetchProfileTextures(r.Context(), username)
+	if err == nil {
+		meta.SkinURL = textures.Textures.Skin.URL
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(meta)
+}
+
+type SkinHash struct {
+	Username  string    `json:"username"`
+	SHA256    string    `json:"sha256"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// skinHashPage lets clients spot a changed skin without downloading it. Only
+// the decoded skin is kept, so the hash is of the PNG /skin/ serves.
+func skinHashPage(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["username"]
+
+	skin, cacheStatus := fetchSkin(r.Context(), skinFetcher, username)
+	data, err := encodeSkin(skin)
+	if err != nil {
+		serverErrorPage(w, r)
+		return
+	}
+	sum := sha256.Sum256(data)
+
+	w.Header().Add("Content-Type", "application/json")
+	setResultHeaders(w, cacheStatus)
+	json.NewEncoder(w).Encode(SkinHash{
+		Username:  username,
+		SHA256:    hex.EncodeToString(sum[:]),
+		FetchedAt: time.Now().UTC(),
+	})
+}
+
+const (
+	DefaultPaletteColours = 5
+	MaxPaletteColours     = 16
+)
+
+type SkinPalette struct {
+	Colors []string `json:"colors"`
+}
+
+// skinPalettePage returns the ?k= most dominant colours of the skin.
+func skinPalettePage(w http.ResponseWriter, r *http.Request) {
+	k, err := strconv.Atoi(r.URL.Query().Get("k"))
+	if err != nil || k < 1 {
+		k = DefaultPaletteColours
+	} else if k > MaxPaletteColours {
+		k = MaxPaletteColours
+	}
+
+	skin, cacheStatus := fetchSkin(r.Context(), skinFetcher, mux.Vars(r)["username"])
+	palette := SkinPalette{Colors: []string{}}
+	for _, c := range render.Palette(skin.Image, k) {
+		palette.Colors = append(palette.Colors, fmt.Sprintf("#%02X%02X%02X", c.R, c.G, c.B))
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	setResultHeaders(w, cacheStatus)
+	json.NewEncoder(w).Encode(palette)
+}
+
+type AvatarDataURI struct {
+	Username string `json:"username"`
+	DataURI  string `json:"data_uri"`
+}
+
+// avatarBase64Page serves the avatar as a data URI, for pages that embed it
+// inline rather than making another request.
+func avatarBase64Page(w http.ResponseWriter, r *http.Request) {
+	data, cacheStatus, err := renderAvatarPNG(w, r)
+	if err != nil {
+		serverErrorPage(w, r)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	setResultHeaders(w, cacheStatus)
+	json.NewEncoder(w).Encode(AvatarDataURI{
+		Username: mux.Vars(r)["username"],
+		DataURI:  "data:image/png;base64," + base64.StdEncoding.EncodeToString(data),
+	})
+}
+
+type TextureInfo struct {
+	SkinURL string `json:"skin_url"`
+	CapeURL string `json:"cape_url"`
+	Model   string `json:"model"`
+	UUID    string `json:"uuid"`
+}
+
+// texturePage serves the texture details from the player's Mojang profile,
+// without fetching the textures themselves.
+func texturePage(w http.ResponseWriter, r *http.Request) {
+	textures, err := fetchProfileTextures(r.Context(), mux.Vars(r)["username"])
+	if err != nil {
+		notFoundPage(w, r)
+		return
+	}
+
+	info := TextureInfo{
+		SkinURL: textures.Textures.Skin.URL,
+		CapeURL: textures.Textures.Cape.URL,
+		Model:   "classic",
+		UUID:    textures.ProfileId,
+	}
+	if textures.Textures.Skin.Metadata.Model == "slim" {
+		info.Model = "slim"
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	addCacheTimeoutHeader(w, config.TTLActualSkinSeconds)
+	json.NewEncoder(w).Encode(info)
+}
+
+// Set at build time with -ldflags "-X main.buildTime=..."
+var buildTime string
+
+type VersionInfo struct {
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version"`
+	BuiltAt   string `json:"built_at"`
+}
+
+func versionPage(w http.ResponseWriter, r *http.Request) {
+	if strings.Contains(r.Header.Get("Accept"), "text/plain") {
+		fmt.Fprintf(w, "%s", MinotarVersion)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(VersionInfo{
+		Version:   MinotarVersion,
+		GoVersion: runtime.Version(),
+		BuiltAt:   buildTime,
+	})
+}
+
 func downloadPage(w http.ResponseWriter, r *http.Request) {
 	headers := w.Header()
-	headers.Add("Content-Disposition", "attachment; filename=\"skin.png\"")
+	headers.Add("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.png\"", mux.Vars(r)["username"]))
 	skinPage(w, r)
 }
 
-func fetchSkin(username string) minecraft.Skin {
-	skin, err := minecraft.GetSkin(minecraft.User{Name: username})
-	if err != nil {
-		// Problem with the returned image, probably means we have an incorrect username
-		// Hit the accounts api
-		user, err := minecraft.GetUser(username)
+// legacyDownloadPage sends links to the old /download/ route on to
+// /skin/{username}/download.
+func legacyDownloadPage(w http.ResponseWriter, r *http.Request) {
+	target := "/skin/" + mux.Vars(r)["username"] + "/download"
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	http.Redirect(w, r, target, http.StatusMovedPermanently)
+}
 
+var fetchGroup singleflight.Group
+
+// fetchSkin returns the skin for a username or UUID and where it came from.
+func fetchSkin(ctx context.Context, fetcher SkinFetcher, identifier string) (minecraft.Skin, CacheStatus) {
+	_, username := normalizeIdentifier(identifier)
+
+	skin, source, errs := newFetchChain(fetcher).Fetch(ctx, username)
+	if ctx.Err() != nil {
+		debug(ctx, "Request cancelled while fetching skin", "username", username, "error", ctx.Err())
+	}
+	status := CacheStatusFallback
+	if source != nil {
+		status = source.Status()
+	}
+
+	if status.IsHit() {
+		atomic.AddUint64(&stats.cacheHits, 1)
+		return skin, status
+	}
+	atomic.AddUint64(&stats.cacheMisses, 1)
+	for _, err := range errs {
+		// Subscribers hear about expiry once, however many caches it expired from
+		if err == ErrSkinExpired {
+			publishInvalidated(username)
+			break
+		}
+	}
+	if status == CacheStatusFallback {
+		debug(ctx, "Serving fallback skin", "username", username, "errors", errs)
+		atomic.AddUint64(&stats.fallbackServed, 1)
+	}
+	return skin, status
+}
+
+// fetchAndCache fetches a skin from Mojang and saves it in the caches. The
+// fetch is abandoned if ctx is cancelled.
+func fetchAndCache(ctx context.Context, fetcher SkinFetcher, username string) (minecraft.Skin, error) {
+	// Concurrent misses for the same player share a single fetch, so they
+	// all wait together if it has to be retried
+	result, err, _ := fetchGroup.Do(username, func() (interface{}, error) {
+		if mojangBreaker != nil {
+			if err := mojangBreaker.Allow(); err != nil {
+				return nil, err
+			}
+		}
+		skin, err := fetchWithRetry(ctx, fetcher, username)
+		if ctx.Err() != nil {
+			// Giving up says nothing about Mojang's health
+			debug(ctx, "Abandoned fetch", "username", username, "error", err)
+			if mojangBreaker != nil {
+				mojangBreaker.Release()
+			}
+			return nil, ctx.Err()
+		}
+		if mojangBreaker != nil {
+			mojangBreaker.Record(err)
+		}
 		if err != nil {
-			// There's no account for this person, serve char
-			skin, _ = minecraft.FetchSkinForChar()
-		} else {
-			// Get valid skin
-			skin, err = minecraft.GetSkin(user)
+			atomic.AddUint64(&stats.mojangErrors, 1)
+			return nil, err
+		}
+
+		cacheInMemory(username, skin)
+		if skinCache != nil {
+			err = skinCache.Save(username, skin)
 			if err != nil {
-				// Their skin somehow errored, fallback
-				skin, _ = minecraft.FetchSkinForChar()
+				log.Printf("Unable to cache skin for %s: %s", username, err)
 			}
 		}
+		return skin, nil
+	})
+	if (err == context.Canceled || err == context.DeadlineExceeded) && ctx.Err() == nil {
+		// The request the fetch was shared with went away, so start another
+		return fetchAndCache(ctx, fetcher, username)
+	}
+	if err != nil {
+		return minecraft.Skin{}, err
 	}
+	return result.(minecraft.Skin), nil
+}
+
+// refreshing holds the usernames currently being refreshed by refreshSkin.
+var refreshing sync.Map
 
+// refreshSkin fetches a fresh copy of a skin in the background, unless that
+// is already happening. If the fetch fails the stale copy is kept.
+func refreshSkin(ctx context.Context, fetcher SkinFetcher, username string) {
+	if _, busy := refreshing.LoadOrStore(username, true); busy {
+		return
+	}
+	// The refresh outlives the request that noticed the skin was stale
+	ctx = detachContext(ctx)
+	go func() {
+		defer refreshing.Delete(username)
+		_, err := fetchAndCache(ctx, fetcher, username)
+		if err != nil {
+			log.Printf("Unable to refresh skin for %s: %s", username, err)
+		}
+	}()
+}
+
+// fetchFallbackSkin returns the configured fallback skin, or char if there
+// isn't one or it can't be used.
+func fetchFallbackSkin(ctx context.Context) minecraft.Skin {
+	skin, _, _ := fallbackChain.Fetch(ctx, "")
 	return skin
 }
 
+func cacheInMemory(username string, skin minecraft.Skin) {
+	if memoryCache != nil {
+		memoryCache.Save(username, skin)
+	}
+}
+
 func main() {
-	avatarPage := fetchImageProcessThen(func(skin minecraft.Skin) (image.Image, error) {
-		return GetHead(skin)
+	flag.Parse()
+
+	var err error
+	config, err = loadConfiguration()
+	if err != nil {
+		log.Fatalln("Unable to load configuration:", err)
+	}
+	setupLogging(config)
+
+	configureMojangClient(config)
+	if config.CircuitBreakerThreshold > 0 {
+		mojangBreaker = NewCircuitBreaker(config.CircuitBreakerThreshold, time.Duration(config.CircuitBreakerCooldownSeconds)*time.Second)
+	}
+
+	skinCache, err = newSkinCache(config)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if dir, ok := localCacheDir(skinCache); ok {
+		err = initDiskCache(dir)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if dir, ok := localCacheDir(skinCache); ok && config.TileCacheEnabled {
+		tileCache = &StaticTileCache{Backend: DiskBackend{Dir: dir}}
+	}
+	if config.SkinUploadToken != "" {
+		uploadStorage, err = newStorageBackend(config)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if disk, ok := uploadStorage.(DiskBackend); ok {
+			err = initDiskCache(disk.Dir)
+			if err != nil {
+				log.Fatalln(err)
+			}
+		}
+	}
+	if config.MemoryCacheSize > 0 {
+		memoryCache = NewMemoryCache(config.MemoryCacheSize, time.Duration(config.TTLActualSkinSeconds)*time.Second)
+	}
+	sessionCache = NewSessionCache(time.Duration(config.TTLActualSkinSeconds) * time.Second)
+	usernameCache = NewSessionCache(time.Duration(UsernameTTL) * time.Second)
+
+	if config.PrefetchList != "" {
+		go warmCache(config.PrefetchList, config.PrefetchConcurrency)
+	}
+
+	avatarPage := fetchImageProcessThen("avatar", func(skin minecraft.Skin) (image.Image, error) {
+		return render.Head(skin)
+	})
+	helmPage := fetchImageProcessThen("helm", func(skin minecraft.Skin) (image.Image, error) {
+		return render.Helm(skin)
+	})
+	avatarBackPage := fetchImageProcessThen("avatar/back", func(skin minecraft.Skin) (image.Image, error) {
+		return render.HeadBack(skin)
 	})
-	helmPage := fetchImageProcessThen(func(skin minecraft.Skin) (image.Image, error) {
-		return GetHelm(skin)
+	avatarBasePage := fetchImageProcessThen("avatar/base", func(skin minecraft.Skin) (image.Image, error) {
+		return render.HeadBase(skin)
+	})
+	avatarOverlayPage := fetchImageProcessThen("avatar/overlay", func(skin minecraft.Skin) (image.Image, error) {
+		return render.HeadOverlay(skin)
+	})
+	helmOverlayPage := fetchImageProcessThen("helm/overlay", func(skin minecraft.Skin) (image.Image, error) {
+		return render.HelmOverlay(skin)
+	})
+	isometricPage := fetchImageProcessThen("isometric", func(skin minecraft.Skin) (image.Image, error) {
+		return render.IsoHead(skin)
+	})
+	bodyPage := fetchImageProcessThen("body", func(skin minecraft.Skin) (image.Image, error) {
+		return render.Body(skin)
+	})
+	overlayPage := fetchImageProcessThen("overlay", func(skin minecraft.Skin) (image.Image, error) {
+		return render.BodyOverlay(skin)
+	})
+	bustPage := fetchImageProcessThen("bust", func(skin minecraft.Skin) (image.Image, error) {
+		return render.Bust(skin)
 	})
 
 	r := mux.NewRouter()
 	r.NotFoundHandler = NotFoundHandler{}
+	r.Use(RequestIDMiddleware)
+	r.Use(CompressMiddleware)
+	if len(config.CORSAllowedOrigins) > 0 {
+		r.Use(NewCORS(config.CORSAllowedOrigins).Middleware)
+	}
+	if config.RateLimitRPS > 0 {
+		r.Use(NewRateLimiter(config.RateLimitRPS, config.RateLimitBurst, config.TrustProxy).Middleware)
+	}
+	if config.PerUserRPS > 0 {
+		r.Use(NewUserRateLimiter(config.PerUserRPS).Middleware)
+	}
 
-	r.HandleFunc("/{username:"+minecraft.ValidUsernameRegex+"}{extension:(.png)?}", avatarPage)
-	r.HandleFunc("/{username:"+minecraft.ValidUsernameRegex+"}/{size:[0-9]+}{extension:(.png)?}", avatarPage)
+	// These must come before the bare /{username} routes, which would
+	// otherwise match them
+	if config.EnableMetrics {
+		registerMetrics()
+		r.Handle("/metrics", promhttp.Handler())
+	}
+	r.HandleFunc("/robots.txt", robotsPage)
+	r.HandleFunc("/version", versionPage)
+	r.HandleFunc("/health", healthPage)
+	r.HandleFunc("/health/mojang", mojangHealthPage)
+	r.HandleFunc("/ready", readyPage)
+	r.HandleFunc("/ping", pingPage)
+	r.HandleFunc("/stats", statsPage)
+	r.HandleFunc("/metrics/histogram", histogramPage)
+	r.HandleFunc("/batch", batchPage).Methods("POST")
+	r.HandleFunc("/webhook/skin-change", skinChangeWebhookPage).Methods("POST")
 
-	r.HandleFunc("/helm/{username:"+minecraft.ValidUsernameRegex+"}{extension:(.png)?}", helmPage)
-	r.HandleFunc("/helm/{username:"+minecraft.ValidUsernameRegex+"}/{size:[0-9]+}{extension:(.png)?}", helmPage)
+	// Image routes are sent to the CDN when there is one
+	imageRoute := func(tpl string, page http.HandlerFunc) *mux.Route {
+		return r.Handle(tpl, CDNMiddleware(page))
+	}
+	imageRoute("/sprite", spritePage)
+	r.HandleFunc("/sprite.json", spriteJSONPage)
+	if config.VersionedAPI {
+		registerV2Routes(r)
+	}
 
-	r.HandleFunc("/download/{username:"+minecraft.ValidUsernameRegex+"}{extension:(.png)?}", downloadPage)
+	imageRoute("/{username:"+ValidIdentifierRegex+"}{extension:(.png)?}", avatarPage)
+	imageRoute("/{username:"+ValidIdentifierRegex+"}/{size:[0-9]+}{extension:(.png)?}", avatarPage)
 
-	r.HandleFunc("/skin/{username:"+minecraft.ValidUsernameRegex+"}{extension:(.png)?}", skinPage)
+	imageRoute("/avatar/{username:"+ValidIdentifierRegex+"}{extension:(.png|.webp)?}", avatarPage)
+	imageRoute("/avatar/{username:"+ValidIdentifierRegex+"}/{size:[0-9]+}{extension:(.png|.webp)?}", avatarPage)
+	imageRoute("/avatar/{username:"+ValidIdentifierRegex+"}/{size:[0-9]+}@{scale:[23]}x{extension:(.png)?}", avatarPage)
 
-	r.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintf(w, "%s", MinotarVersion)
-	})
+	imageRoute("/avatar/{username:"+ValidIdentifierRegex+"}.gif", rotatingHeadPage)
+	imageRoute("/avatar/{username:"+ValidIdentifierRegex+"}/{size:[0-9]+}.gif", rotatingHeadPage)
+	imageRoute("/avatar/{username:"+ValidIdentifierRegex+"}.svg", svgAvatarPage)
+	imageRoute("/avatar/{username:"+ValidIdentifierRegex+"}/{size:[0-9]+}.svg", svgAvatarPage)
+	imageRoute("/avatar/{username:"+ValidIdentifierRegex+"}/{size:[0-9]+}.css", cssAvatarPage)
+
+	imageRoute("/helm/{username:"+ValidIdentifierRegex+"}{extension:(.png)?}", helmPage)
+	imageRoute("/helm/{username:"+ValidIdentifierRegex+"}/{size:[0-9]+}{extension:(.png)?}", helmPage)
+	imageRoute("/helm/{username:"+ValidIdentifierRegex+"}/{size:[0-9]+}@{scale:[23]}x{extension:(.png)?}", helmPage)
+
+	imageRoute("/body/{username:"+ValidIdentifierRegex+"}{extension:(.png)?}", bodyPage)
+	imageRoute("/body/{username:"+ValidIdentifierRegex+"}/{size:[0-9]+}{extension:(.png)?}", bodyPage)
+	imageRoute("/body/{username:"+ValidIdentifierRegex+"}/{size:[0-9]+}@{scale:[23]}x{extension:(.png)?}", bodyPage)
+
+	imageRoute("/isometric/{username:"+ValidIdentifierRegex+"}{extension:(.png)?}", isometricPage)
+	imageRoute("/isometric/{username:"+ValidIdentifierRegex+"}/{size:[0-9]+}{extension:(.png)?}", isometricPage)
+
+	// /helm/back/ is served by render.HelmBack through the face routes below
+	imageRoute("/avatar/back/{username:"+ValidIdentifierRegex+"}{extension:(.png)?}", avatarBackPage)
+	imageRoute("/avatar/back/{username:"+ValidIdentifierRegex+"}/{size:[0-9]+}{extension:(.png)?}", avatarBackPage)
+
+	imageRoute("/avatar/{username:"+ValidIdentifierRegex+"}/base{extension:(.png)?}", avatarBasePage)
+	r.HandleFunc("/avatar/{username:"+ValidIdentifierRegex+"}/base64", avatarBase64Page)
+	imageRoute("/avatar/{username:"+ValidIdentifierRegex+"}/base/{size:[0-9]+}{extension:(.png)?}", avatarBasePage)
+	imageRoute("/avatar/{username:"+ValidIdentifierRegex+"}/overlay{extension:(.png)?}", avatarOverlayPage)
+	imageRoute("/avatar/{username:"+ValidIdentifierRegex+"}/overlay/{size:[0-9]+}{extension:(.png)?}", avatarOverlayPage)
+
+	for face := range render.HeadFaces {
+		face := face
+		facePage := fetchImageProcessThen("helm/"+face, func(skin minecraft.Skin) (image.Image, error) {
+			return render.HelmFace(skin, face)
+		})
+		imageRoute("/helm/"+face+"/{username:"+ValidIdentifierRegex+"}{extension:(.png)?}", facePage)
+		imageRoute("/helm/"+face+"/{username:"+ValidIdentifierRegex+"}/{size:[0-9]+}{extension:(.png)?}", facePage)
+	}
+
+	imageRoute("/helm/overlay/{username:"+ValidIdentifierRegex+"}{extension:(.png)?}", helmOverlayPage)
+	imageRoute("/helm/overlay/{username:"+ValidIdentifierRegex+"}/{size:[0-9]+}{extension:(.png)?}", helmOverlayPage)
+
+	imageRoute("/bust/{username:"+ValidIdentifierRegex+"}{extension:(.png)?}", bustPage)
+	imageRoute("/bust/{username:"+ValidIdentifierRegex+"}/{size:[0-9]+}{extension:(.png)?}", bustPage)
+
+	registerRendererRoutes(r)
+
+	imageRoute("/overlay/{username:"+ValidIdentifierRegex+"}{extension:(.png)?}", overlayPage)
+	imageRoute("/overlay/{username:"+ValidIdentifierRegex+"}/{size:[0-9]+}{extension:(.png)?}", overlayPage)
+
+	imageRoute("/armor/{username:"+ValidIdentifierRegex+"}{extension:(.png)?}", armorPage)
+	imageRoute("/armor/{username:"+ValidIdentifierRegex+"}/{size:[0-9]+}{extension:(.png)?}", armorPage)
+
+	imageRoute("/cape/{username:"+ValidIdentifierRegex+"}{extension:(.png)?}", capePage)
+	imageRoute("/cape/{username:"+ValidIdentifierRegex+"}/{size:[0-9]+}{extension:(.png)?}", capePage)
+
+	imageRoute("/diff/{usernameA:"+ValidIdentifierRegex+"}/{usernameB:"+ValidIdentifierRegex+"}{extension:(.png)?}", diffPage)
+
+	r.HandleFunc("/download/{username:"+ValidIdentifierRegex+"}{extension:(.png)?}", legacyDownloadPage)
+
+	r.HandleFunc("/skin/upload", uploadPage).Methods("POST")
+	imageRoute("/skin/{username:"+ValidIdentifierRegex+"}{extension:(.png)?}", skinPage).Methods("HEAD")
+	imageRoute("/skin/{username:"+ValidIdentifierRegex+"}{extension:(.png)?}", skinPage)
+	r.HandleFunc("/skin/{username:"+ValidIdentifierRegex+"}/meta", skinMetaPage)
+	r.HandleFunc("/skin/{username:"+ValidIdentifierRegex+"}/hash", skinHashPage)
+	r.HandleFunc("/skin/{username:"+ValidIdentifierRegex+"}/palette", skinPalettePage)
+	imageRoute("/skin/{username:"+ValidIdentifierRegex+"}/thumbnail", thumbnailPage)
+	imageRoute("/skin/{username:"+ValidIdentifierRegex+"}/download", downloadPage)
+	r.HandleFunc("/skin/{username:"+ValidIdentifierRegex+"}/events", eventsPage)
+	r.HandleFunc("/texture/{username:"+ValidIdentifierRegex+"}", texturePage)
+	r.HandleFunc("/minecraft/session/{uuid:"+ValidUUIDRegex+"}", sessionPage)
+	r.HandleFunc("/username/{uuid:"+ValidUUIDRegex+"}", usernamePage)
+
+	r.HandleFunc("/cache/invalidate/{username:"+ValidIdentifierRegex+"}", invalidatePage).Methods("POST")
 
 	r.HandleFunc("/", indexPage)
 
 	http.Handle("/", r)
 	http.HandleFunc("/assets/", serveAssetPage)
-	log.Fatalln(http.ListenAndServe(ListenOn, nil))
+
+	err = runServer(&http.Server{Handler: SecurityHeadersMiddleware(http.DefaultServeMux)})
+	if err != nil {
+		log.Fatalln(err)
+	}
 }
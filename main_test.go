@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/applenick/appletar/render"
+	"github.com/applenick/minecraft"
+	"github.com/gorilla/mux"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// MockFetcher serves skins from memory instead of asking Mojang. Skins is
+// keyed by lowercased username, which is how fetchSkin asks for them.
+type MockFetcher struct {
+	Skins map[string]minecraft.Skin
+	Calls int
+}
+
+func (m *MockFetcher) Fetch(ctx context.Context, username string) (minecraft.Skin, error) {
+	m.Calls++
+	skin, ok := m.Skins[username]
+	if !ok {
+		return minecraft.Skin{}, errors.New("No such user")
+	}
+	return skin, nil
+}
+
+func loadFixtureSkin(t testing.TB) minecraft.Skin {
+	f, err := os.Open("testdata/skin.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return minecraft.Skin{Image: img}
+}
+
+func TestNormalizeIdentifier(t *testing.T) {
+	tests := []struct {
+		in     string
+		isUUID bool
+		out    string
+	}{
+		{"Notch", false, "notch"},
+		{"notch", false, "notch"},
+		{"069A79F4-44E9-4726-A5BE-FCA90E38AAF5", true, "069a79f444e94726a5befca90e38aaf5"},
+	}
+	for _, test := range tests {
+		isUUID, out := normalizeIdentifier(test.in)
+		if isUUID != test.isUUID || out != test.out {
+			t.Errorf("normalizeIdentifier(%q) = %v, %q, expected %v, %q", test.in, isUUID, out, test.isUUID, test.out)
+		}
+	}
+}
+
+func TestFetchSkinUsesFetcher(t *testing.T) {
+	fetcher := &MockFetcher{Skins: map[string]minecraft.Skin{"notch": loadFixtureSkin(t)}}
+
+	skin, status := fetchSkin(context.Background(), fetcher, "Notch")
+	if status != CacheStatusNetwork {
+		t.Errorf("Expected %q with no caches configured, got %q", CacheStatusNetwork, status)
+	}
+	if skin.Image == nil || skin.Image.Bounds() != image.Rect(0, 0, 64, 64) {
+		t.Errorf("Expected the fixture skin, got %v", skin.Image)
+	}
+	if fetcher.Calls != 1 {
+		t.Errorf("Expected 1 call to the fetcher, got %d", fetcher.Calls)
+	}
+}
+
+func TestFetchSkinMemoryCache(t *testing.T) {
+	oldConfig := config
+	config = defaultConfiguration()
+	defer func() { config = oldConfig }()
+	memoryCache = NewMemoryCache(10, time.Minute)
+	defer func() { memoryCache = nil }()
+
+	fetcher := &MockFetcher{Skins: map[string]minecraft.Skin{"notch": loadFixtureSkin(t)}}
+
+	fetchSkin(context.Background(), fetcher, "Notch")
+	_, status := fetchSkin(context.Background(), fetcher, "Notch")
+	if status != CacheStatusMemory {
+		t.Errorf("Expected the second fetch to hit the memory cache, got %q", status)
+	}
+	if fetcher.Calls != 1 {
+		t.Errorf("Expected 1 call to the fetcher, got %d", fetcher.Calls)
+	}
+}
+
+// blockingFetcher never finds a skin, waiting until it is abandoned.
+type blockingFetcher struct{}
+
+func (blockingFetcher) Fetch(ctx context.Context, username string) (minecraft.Skin, error) {
+	<-ctx.Done()
+	return minecraft.Skin{}, ctx.Err()
+}
+
+func TestFetchSkinCancelled(t *testing.T) {
+	oldConfig := config
+	config = defaultConfiguration()
+	defer func() { config = oldConfig }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	done := make(chan CacheStatus)
+	go func() {
+		_, status := fetchSkin(ctx, blockingFetcher{}, "Notch")
+		done <- status
+	}()
+
+	select {
+	case status := <-done:
+		if status != CacheStatusFallback {
+			t.Errorf("Expected %q once the request was cancelled, got %q", CacheStatusFallback, status)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Fetch wasn't abandoned when the request was cancelled")
+	}
+}
+
+// staticSource is a SkinSource that either always or never has the skin.
+type staticSource struct {
+	found bool
+	calls int
+}
+
+func (s *staticSource) FetchSkin(ctx context.Context, username string) (minecraft.Skin, bool, error) {
+	s.calls++
+	if !s.found {
+		return minecraft.Skin{}, false, ErrNotCached
+	}
+	return minecraft.Skin{}, true, nil
+}
+
+func (s *staticSource) Status() CacheStatus { return CacheStatusDisk }
+
+func TestValidateSkinImage(t *testing.T) {
+	tests := []struct {
+		name  string
+		img   image.Image
+		valid bool
+	}{
+		{"fixture", loadFixtureSkin(t).Image, true},
+		{"missing", nil, false},
+		{"wrong size", image.NewNRGBA(image.Rect(0, 0, 32, 32)), false},
+		{"paletted", image.NewPaletted(image.Rect(0, 0, 64, 64), nil), false},
+		{"single colour", image.NewNRGBA(image.Rect(0, 0, 64, 64)), false},
+	}
+	for _, test := range tests {
+		err := validateSkinImage(test.img)
+		if test.valid && err != nil {
+			t.Errorf("%s: expected a valid skin, got %s", test.name, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("%s: expected an invalid skin", test.name)
+		}
+	}
+}
+
+func TestFetchChain(t *testing.T) {
+	missing, present, unreached := &staticSource{}, &staticSource{found: true}, &staticSource{found: true}
+	chain := FetchChain{Sources: []SkinSource{missing, present, unreached}}
+
+	_, source, errs := chain.Fetch(context.Background(), "Notch")
+	if source != present {
+		t.Errorf("Expected the first source with the skin to find it, got %v", source)
+	}
+	if len(errs) != 1 || errs[0] != ErrNotCached {
+		t.Errorf("Expected the missing source's error, got %v", errs)
+	}
+	if missing.calls != 1 || unreached.calls != 0 {
+		t.Errorf("Expected the chain to stop at the first find, got %d and %d calls", missing.calls, unreached.calls)
+	}
+
+	_, source, errs = FetchChain{Sources: []SkinSource{missing}}.Fetch(context.Background(), "Notch")
+	if source != nil || len(errs) != 1 {
+		t.Errorf("Expected no source and one error when nothing has the skin, got %v and %v", source, errs)
+	}
+}
+
+func TestAvatarPage(t *testing.T) {
+	oldConfig := config
+	config = defaultConfiguration()
+	defer func() { config = oldConfig }()
+
+	oldFetcher := skinFetcher
+	skinFetcher = &MockFetcher{Skins: map[string]minecraft.Skin{"notch": loadFixtureSkin(t)}}
+	defer func() { skinFetcher = oldFetcher }()
+
+	r := mux.NewRouter()
+	r.HandleFunc("/avatar/{username}/{size:[0-9]+}", fetchImageProcessThen("avatar", render.Head))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/avatar/Notch/64", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "image/png" {
+		t.Errorf("Expected image/png, got %s", ct)
+	}
+
+	img, err := png.Decode(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := img.Bounds().Size(); size != image.Pt(64, 64) {
+		t.Errorf("Expected a 64x64 avatar, got %v", size)
+	}
+}
+
+func TestAvatarPageTileInvalidated(t *testing.T) {
+	oldConfig := config
+	config = defaultConfiguration()
+	defer func() { config = oldConfig }()
+
+	const uuid = "069a79f444e94726a5befca90e38aaf5"
+	oldFetcher := skinFetcher
+	skinFetcher = &MockFetcher{Skins: map[string]minecraft.Skin{uuid: loadFixtureSkin(t)}}
+	defer func() { skinFetcher = oldFetcher }()
+
+	dir := t.TempDir()
+	oldTileCache := tileCache
+	tileCache = &StaticTileCache{Backend: DiskBackend{Dir: dir}}
+	defer func() { tileCache = oldTileCache }()
+
+	r := mux.NewRouter()
+	r.HandleFunc("/avatar/{username}/{size:[0-9]+}", fetchImageProcessThen("avatar", render.Head))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/avatar/069a79f4-44e9-4726-a5be-fca90e38aaf5/64", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("Expected the avatar to be saved as a tile, got %v", entries)
+	}
+
+	err := invalidateSkin(uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Expected invalidating the undashed UUID to delete the tile, got %v", entries)
+	}
+}
+
+func TestSkinPageHead(t *testing.T) {
+	oldFetcher := skinFetcher
+	skinFetcher = &MockFetcher{Skins: map[string]minecraft.Skin{"notch": loadFixtureSkin(t)}}
+	defer func() { skinFetcher = oldFetcher }()
+
+	r := mux.NewRouter()
+	r.HandleFunc("/skin/{username}", skinPage).Methods("HEAD")
+	r.HandleFunc("/skin/{username}", skinPage)
+
+	get := httptest.NewRecorder()
+	r.ServeHTTP(get, httptest.NewRequest("GET", "/skin/Notch", nil))
+	head := httptest.NewRecorder()
+	r.ServeHTTP(head, httptest.NewRequest("HEAD", "/skin/Notch", nil))
+
+	if head.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", head.Code)
+	}
+	if head.Body.Len() != 0 {
+		t.Errorf("Expected no body for HEAD, got %d bytes", head.Body.Len())
+	}
+	for _, header := range []string{"Content-Type", "Content-Length", "X-Result"} {
+		if head.Header().Get(header) != get.Header().Get(header) {
+			t.Errorf("Expected %s to match GET's %q, got %q", header, get.Header().Get(header), head.Header().Get(header))
+		}
+	}
+	if cl := head.Header().Get("Content-Length"); cl != strconv.Itoa(get.Body.Len()) {
+		t.Errorf("Expected Content-Length %d, got %s", get.Body.Len(), cl)
+	}
+}
+
+// pushRecorder is a ResponseRecorder that supports HTTP/2 push.
+type pushRecorder struct {
+	*httptest.ResponseRecorder
+	pushed []string
+}
+
+func (p *pushRecorder) Push(target string, opts *http.PushOptions) error {
+	p.pushed = append(p.pushed, target)
+	return nil
+}
+
+func TestCompressMiddlewarePush(t *testing.T) {
+	oldConfig := config
+	config = defaultConfiguration()
+	defer func() { config = oldConfig }()
+
+	handler := CompressMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pusher, ok := w.(http.Pusher)
+		if !ok {
+			t.Fatal("Expected the compressing writer to support push")
+		}
+		pusher.Push(IndexAvatarPath, nil)
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := &pushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	handler.ServeHTTP(rec, req)
+	if len(rec.pushed) != 1 || rec.pushed[0] != IndexAvatarPath {
+		t.Errorf("Expected %s to be pushed, got %v", IndexAvatarPath, rec.pushed)
+	}
+}
+
+func TestCDNMiddleware(t *testing.T) {
+	oldConfig := config
+	config = defaultConfiguration()
+	config.CDNBaseURL = "https://cdn.example.com/"
+	config.CDNSecret = "s3cret"
+	defer func() { config = oldConfig }()
+
+	handler := CDNMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		status int
+	}{
+		{"client", "", "", http.StatusFound},
+		{"proxy", "Via", "1.1 proxy", http.StatusFound},
+		{"wrong secret", CDNSecretHeader, "guess", http.StatusFound},
+		{"cdn", CDNSecretHeader, "s3cret", http.StatusOK},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/avatar/Notch/64.png?overlay", nil)
+		if test.header != "" {
+			req.Header.Set(test.header, test.value)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("%s: expected %d, got %d", test.name, test.status, rec.Code)
+		}
+		if test.status == http.StatusFound {
+			if location := rec.Header().Get("Location"); location != "https://cdn.example.com/avatar/Notch/64.png?overlay" {
+				t.Errorf("%s: expected a redirect to the CDN's copy, got %q", test.name, location)
+			}
+		}
+	}
+}
+
+func TestBatchPageBodyLimit(t *testing.T) {
+	oldConfig := config
+	config = defaultConfiguration()
+	defer func() { config = oldConfig }()
+
+	body := `{"usernames": ["` + strings.Repeat("a", MaxBatchRequestBytes) + `"]}`
+	rec := httptest.NewRecorder()
+	batchPage(rec, httptest.NewRequest("POST", "/batch", strings.NewReader(body)))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "too large") {
+		t.Errorf("expected the oversized body to be refused, got %d: %s", rec.Code, rec.Body)
+	}
+}
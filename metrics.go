@@ -0,0 +1,66 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"os"
+	"path/filepath"
+)
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "minotar_requests_total",
+		Help: "Rendered image requests by endpoint and result (ok, failed or cached).",
+	}, []string{"endpoint", "result"})
+
+	fetchSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "minotar_fetch_seconds",
+		Help: "Time taken to fetch a skin, from cache or from Mojang.",
+	}, []string{"endpoint"})
+	processSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "minotar_process_seconds",
+		Help: "Time taken to render a skin.",
+	}, []string{"endpoint"})
+	resizeSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "minotar_resize_seconds",
+		Help: "Time taken to resize a render.",
+	}, []string{"endpoint"})
+
+	diskCacheBytes = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "minotar_disk_cache_bytes",
+		Help: "Total size of the skins in the disk cache.",
+	}, diskCacheSize)
+)
+
+func registerMetrics() {
+	prometheus.MustRegister(requestsTotal, fetchSeconds, processSeconds, resizeSeconds, diskCacheBytes)
+}
+
+// recordMetrics reuses the timings gathered for the access log.
+func recordMetrics(entry *AccessLogEntry) {
+	if !config.EnableMetrics {
+		return
+	}
+
+	result := "ok"
+	if entry.StatusCode >= 500 {
+		result = "failed"
+	} else if entry.CacheHit {
+		result = "cached"
+	}
+	requestsTotal.WithLabelValues(entry.Endpoint, result).Inc()
+
+	fetchSeconds.WithLabelValues(entry.Endpoint).Observe(float64(entry.FetchMs) / 1000)
+	processSeconds.WithLabelValues(entry.Endpoint).Observe(float64(entry.ProcessMs) / 1000)
+	resizeSeconds.WithLabelValues(entry.Endpoint).Observe(float64(entry.ResizeMs) / 1000)
+}
+
+func diskCacheSize() float64 {
+	var total int64
+	filepath.Walk(SkinCacheLocation, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return float64(total)
+}
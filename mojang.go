@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/applenick/minecraft"
+	"image/png"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultMojangAPIBaseURL     = "https://api.mojang.com"
+	DefaultMojangSessionBaseURL = "https://sessionserver.mojang.com"
+
+	UsernameLookupPath = "/users/profiles/minecraft/"
+	SessionProfilePath = "/session/minecraft/profile/"
+
+	// Shortest we back off for when Mojang rate limits us, which is also
+	// used if they don't say how long to wait
+	MinRetryAfter = time.Second
+)
+
+var ErrNoSkin = errors.New("Profile has no skin")
+
+// mojangClient makes all of our requests to Mojang. It is kept apart from
+// http.DefaultClient so that its timeout and connection pool don't apply to
+// our other requests, such as webhooks and S3.
+var mojangClient = &http.Client{}
+
+// configureMojangClient sets mojangClient's timeout, and gives it a pool of
+// connections sized for the traffic we send Mojang.
+func configureMojangClient(cfg MinotarConfig) {
+	mojangClient.Timeout = time.Duration(cfg.MojangTimeoutSeconds) * time.Second
+	mojangClient.Transport = &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2: true,
+		MaxIdleConns:      cfg.HTTPMaxIdleConns,
+		// Nearly everything goes to a couple of hosts, so they may use the
+		// whole pool rather than the default of 2 idle connections each
+		MaxIdleConnsPerHost: cfg.HTTPMaxIdleConns,
+		IdleConnTimeout:     time.Duration(cfg.HTTPIdleConnTimeoutSeconds) * time.Second,
+		TLSHandshakeTimeout: time.Duration(cfg.HTTPTLSHandshakeTimeoutSeconds) * time.Second,
+	}
+}
+
+// RateLimitedError is returned when Mojang answers 429 Too Many Requests.
+type RateLimitedError struct {
+	RetryAfter time.Duration
+}
+
+func (e RateLimitedError) Error() string {
+	return fmt.Sprintf("Rate limited by Mojang, retry after %s", e.RetryAfter)
+}
+
+// newRateLimitedError reads how long Mojang wants us to wait from the
+// Retry-After header of resp, which is either in seconds or a date.
+func newRateLimitedError(resp *http.Response) RateLimitedError {
+	var retryAfter time.Duration
+	header := resp.Header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(header); err == nil {
+		retryAfter = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(header); err == nil {
+		retryAfter = time.Until(date)
+	}
+	if retryAfter < MinRetryAfter {
+		retryAfter = MinRetryAfter
+	}
+	return RateLimitedError{RetryAfter: retryAfter}
+}
+
+// isTimeout is true if err came from Mojang taking too long to answer,
+// rather than from them answering that something doesn't exist.
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// SessionProfile is a player's profile as returned by Mojang's session server.
+type SessionProfile struct {
+	Id         string            `json:"id"`
+	Name       string            `json:"name"`
+	Properties []ProfileProperty `json:"properties"`
+}
+
+type ProfileProperty struct {
+	Name      string `json:"name"`
+	Value     string `json:"value"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// ProfileTextures is the base64 decoded "textures" property of a profile.
+type ProfileTextures struct {
+	Timestamp   int64  `json:"timestamp"`
+	ProfileId   string `json:"profileId"`
+	ProfileName string `json:"profileName"`
+	Textures    struct {
+		Skin struct {
+			URL      string `json:"url"`
+			Metadata struct {
+				Model string `json:"model"`
+			} `json:"metadata"`
+		} `json:"SKIN"`
+		Cape struct {
+			URL string `json:"url"`
+		} `json:"CAPE"`
+	} `json:"textures"`
+}
+
+func usernameLookupURL(username string) string {
+	return strings.TrimSuffix(config.MojangAPIBaseURL, "/") + UsernameLookupPath + username
+}
+
+func sessionProfileURL(uuid string) string {
+	return strings.TrimSuffix(config.MojangSessionBaseURL, "/") + SessionProfilePath + uuid
+}
+
+// fetchUUID looks up the UUID of the player currently using username.
+func fetchUUID(ctx context.Context, username string) (string, error) {
+	resp, err := mojangGet(ctx, usernameLookupURL(username))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return "", newRateLimitedError(resp)
+	} else if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Username lookup returned %s for %s", resp.Status, username)
+	}
+
+	var profile SessionProfile
+	err = json.NewDecoder(resp.Body).Decode(&profile)
+	return profile.Id, err
+}
+
+// fetchProfileTextures looks up the textures of the player with the given
+// username or UUID.
+func fetchProfileTextures(ctx context.Context, identifier string) (ProfileTextures, error) {
+	isUUID, uuid := normalizeIdentifier(identifier)
+	if !isUUID {
+		var err error
+		uuid, err = fetchUUID(ctx, identifier)
+		if err != nil {
+			return ProfileTextures{}, err
+		}
+	}
+
+	profile, err := fetchSessionProfile(ctx, uuid)
+	if err != nil {
+		return ProfileTextures{}, err
+	}
+	return profile.Textures()
+}
+
+func fetchSessionProfile(ctx context.Context, uuid string) (SessionProfile, error) {
+	var profile SessionProfile
+
+	resp, err := mojangGet(ctx, sessionProfileURL(uuid))
+	if err != nil {
+		return profile, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return profile, newRateLimitedError(resp)
+	} else if resp.StatusCode != http.StatusOK {
+		return profile, fmt.Errorf("Session server returned %s for %s", resp.Status, uuid)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&profile)
+	return profile, err
+}
+
+func (p SessionProfile) Textures() (ProfileTextures, error) {
+	var textures ProfileTextures
+	for _, prop := range p.Properties {
+		if prop.Name != "textures" {
+			continue
+		}
+
+		data, err := base64.StdEncoding.DecodeString(prop.Value)
+		if err != nil {
+			return textures, err
+		}
+		err = json.Unmarshal(data, &textures)
+		return textures, err
+	}
+	return textures, ErrNoSkin
+}
+
+func fetchSkinForUUID(ctx context.Context, uuid string) (minecraft.Skin, error) {
+	profile, err := fetchSessionProfile(ctx, uuid)
+	if err != nil {
+		return minecraft.Skin{}, err
+	}
+
+	textures, err := profile.Textures()
+	if err != nil {
+		return minecraft.Skin{}, err
+	}
+	if textures.Textures.Skin.URL == "" {
+		return minecraft.Skin{}, ErrNoSkin
+	}
+	skin, err := fetchSkinFromURL(ctx, textures.Textures.Skin.URL)
+	if err == nil {
+		// A corrupt skin is no better than none, so falls back the same way
+		err = validateSkinImage(skin.Image)
+	}
+	return skin, err
+}
+
+func fetchSkinFromURL(ctx context.Context, url string) (minecraft.Skin, error) {
+	resp, err := mojangGet(ctx, url)
+	if err != nil {
+		return minecraft.Skin{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return minecraft.Skin{}, newRateLimitedError(resp)
+	} else if resp.StatusCode != http.StatusOK {
+		return minecraft.Skin{}, fmt.Errorf("Fetching %s returned %s", url, resp.Status)
+	}
+
+	img, err := png.Decode(resp.Body)
+	if err != nil {
+		return minecraft.Skin{}, err
+	}
+	return minecraft.Skin{Image: img}, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// withMojangServer points the Mojang API and session server at handler for
+// the rest of the test.
+func withMojangServer(t *testing.T, handler http.Handler) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	oldConfig := config
+	config = defaultConfiguration()
+	config.MojangAPIBaseURL = server.URL
+	config.MojangSessionBaseURL = server.URL
+	t.Cleanup(func() {
+		config = oldConfig
+		server.Close()
+	})
+}
+
+func TestMojangFetcherCancelled(t *testing.T) {
+	cancelled := make(chan struct{})
+	withMojangServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		close(cancelled)
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := MojangFetcher{}.Fetch(ctx, "Notch")
+	if err == nil {
+		t.Fatal("Expected the fetch to fail once cancelled")
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("Expected the request to Mojang to be cancelled")
+	}
+}
+
+func TestFetchWithRetryRateLimited(t *testing.T) {
+	lookups := 0
+	var serverURL string
+	mux := http.NewServeMux()
+	mux.HandleFunc(UsernameLookupPath+"Notch", func(w http.ResponseWriter, r *http.Request) {
+		lookups++
+		if lookups == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		json.NewEncoder(w).Encode(SessionProfile{Id: "069a79f444e94726a5befca90e38aaf5", Name: "Notch"})
+	})
+	mux.HandleFunc(SessionProfilePath+"069a79f444e94726a5befca90e38aaf5", func(w http.ResponseWriter, r *http.Request) {
+		textures := `{"textures":{"SKIN":{"url":"` + serverURL + `/skin.png"}}}`
+		json.NewEncoder(w).Encode(SessionProfile{
+			Id:         "069a79f444e94726a5befca90e38aaf5",
+			Name:       "Notch",
+			Properties: []ProfileProperty{{Name: "textures", Value: base64.StdEncoding.EncodeToString([]byte(textures))}},
+		})
+	})
+	mux.HandleFunc("/skin.png", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "testdata/skin.png")
+	})
+	withMojangServer(t, mux)
+	serverURL = config.MojangAPIBaseURL
+
+	skin, err := fetchWithRetry(context.Background(), MojangFetcher{}, "Notch")
+	if err != nil {
+		t.Fatalf("Expected the fetch to succeed after being rate limited, got %s", err)
+	}
+	if err := validateSkinImage(skin.Image); err != nil {
+		t.Errorf("Expected the fixture skin, got %s", err)
+	}
+	if lookups != 2 {
+		t.Errorf("Expected the rate limited lookup to be retried once, got %d lookups", lookups)
+	}
+}
+
+func TestMojangFetcherForwardsRequestID(t *testing.T) {
+	ids := make(chan string, 1)
+	withMojangServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case ids <- r.Header.Get(RequestIDHeader):
+		default:
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+
+	MojangFetcher{}.Fetch(withRequestID(context.Background(), "abc123"), "Notch")
+	if id := <-ids; id != "abc123" {
+		t.Errorf("Expected the username lookup to carry request ID %q, got %q", "abc123", id)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	RateLimitSweepInterval = 5 * time.Minute
+)
+
+// RateLimiter is a token-bucket limiter keeping one bucket per remote IP.
+type RateLimiter struct {
+	rate       float64
+	burst      float64
+	trustProxy bool
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+func NewRateLimiter(rps float64, burst int, trustProxy bool) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	rl := &RateLimiter{
+		rate:       rps,
+		burst:      float64(burst),
+		trustProxy: trustProxy,
+		buckets:    make(map[string]*tokenBucket),
+	}
+	go rl.sweep()
+	return rl
+}
+
+// allow takes a token from the bucket for ip, returning how long the client
+// should wait before retrying if the bucket is empty.
+func (rl *RateLimiter) allow(ip string) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	b, ok := rl.buckets[ip]
+	if !ok {
+		b = &tokenBucket{tokens: rl.burst, lastSeen: now}
+		rl.buckets[ip] = b
+	}
+
+	b.tokens = math.Min(rl.burst, b.tokens+now.Sub(b.lastSeen).Seconds()*rl.rate)
+	b.lastSeen = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / rl.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep forgets buckets which have been idle long enough to be full again.
+func (rl *RateLimiter) sweep() {
+	for range time.Tick(RateLimitSweepInterval) {
+		rl.mu.Lock()
+		for ip, b := range rl.buckets {
+			if time.Since(b.lastSeen) > RateLimitSweepInterval {
+				delete(rl.buckets, ip)
+			}
+		}
+		rl.mu.Unlock()
+	}
+}
+
+func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, wait := rl.allow(RealIP(r, rl.trustProxy))
+		if !ok {
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Ceil(wait.Seconds()))))
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprintf(w, "429 too many requests")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
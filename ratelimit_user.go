@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"golang.org/x/time/rate"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// UserRateLimiter limits how often each player can be requested, whoever is
+// asking, so that one very popular player can't hog the server.
+type UserRateLimiter struct {
+	limit rate.Limit
+	burst int
+
+	limiters sync.Map // username to *rate.Limiter
+}
+
+func NewUserRateLimiter(rps float64) *UserRateLimiter {
+	ul := &UserRateLimiter{
+		limit: rate.Limit(rps),
+		burst: int(math.Max(1, math.Ceil(rps))),
+	}
+	go ul.sweep()
+	return ul
+}
+
+func (ul *UserRateLimiter) limiter(username string) *rate.Limiter {
+	limiter, ok := ul.limiters.Load(username)
+	if !ok {
+		limiter, _ = ul.limiters.LoadOrStore(username, rate.NewLimiter(ul.limit, ul.burst))
+	}
+	return limiter.(*rate.Limiter)
+}
+
+// sweep forgets players whose limiters have refilled, as a new limiter would
+// behave exactly the same.
+func (ul *UserRateLimiter) sweep() {
+	for range time.Tick(RateLimitSweepInterval) {
+		ul.limiters.Range(func(username, limiter interface{}) bool {
+			if limiter.(*rate.Limiter).Tokens() >= float64(ul.burst) {
+				ul.limiters.Delete(username)
+			}
+			return true
+		})
+	}
+}
+
+func (ul *UserRateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identifier, ok := mux.Vars(r)["username"]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		_, username := normalizeIdentifier(identifier)
+		if !ul.limiter(username).Allow() {
+			w.Header().Set("X-Rate-Limited-Username", username)
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprintf(w, "429 too many requests")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// RealIP returns the IP address of the client making r. Behind a trusted
+// reverse proxy that is the first public address in X-Forwarded-For, or
+// else X-Real-IP, rather than the proxy's own address.
+func RealIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		for _, addr := range strings.Split(r.Header.Get("X-Forwarded-For"), ",") {
+			ip := net.ParseIP(strings.TrimSpace(addr))
+			if ip != nil && !ip.IsPrivate() && !ip.IsLoopback() {
+				return ip.String()
+			}
+		}
+		if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+			return ip.String()
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,45 @@
+package render
+
+import (
+	"github.com/applenick/minecraft"
+	"image"
+	"image/draw"
+)
+
+// ArmorPieces are the pieces of armor Armored draws, in the order they are
+// layered onto the body.
+var ArmorPieces = []string{"boots", "leggings", "chestplate", "helmet"}
+
+// armorRegions gives the part of a body render each piece of armor covers.
+var armorRegions = map[string]image.Rectangle{
+	"boots":      image.Rect(4, 26, 12, 32),
+	"leggings":   image.Rect(4, 17, 12, 28),
+	"chestplate": image.Rect(0, 8, 16, 20),
+	"helmet":     image.Rect(4, 0, 12, 8),
+}
+
+// Armored renders the front of the character as Body does, wearing the
+// given armor. armorLayers holds a texture for each of ArmorPieces, in the
+// same order, laid out like a legacy skin as Minecraft's armor textures are.
+// Pieces with a nil texture aren't worn.
+func Armored(skin minecraft.Skin, armorLayers []image.Image) (image.Image, error) {
+	bodyImg, err := Body(skin)
+	if err != nil {
+		return nil, err
+	}
+	outIm := bodyImg.(draw.Image)
+
+	for i, layer := range armorLayers {
+		if layer == nil || i >= len(ArmorPieces) {
+			continue
+		}
+
+		armorImg, err := Body(minecraft.Skin{Image: layer})
+		if err != nil {
+			return nil, err
+		}
+		region := armorRegions[ArmorPieces[i]]
+		draw.Draw(outIm, region, armorImg, region.Min, draw.Over)
+	}
+	return outIm, nil
+}
@@ -0,0 +1,49 @@
+package render
+
+import (
+	"github.com/applenick/minecraft"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+var (
+	DiffRemoved = color.RGBA{255, 0, 0, 255}
+	DiffAdded   = color.RGBA{0, 255, 0, 255}
+)
+
+// Diff compares two skins pixel by pixel, both normalized to 64x64. Pixels
+// only a has are marked in DiffRemoved, and pixels b has but a doesn't, or
+// has in a different colour, in DiffAdded. Pixels they share are drawn at
+// half opacity, or left out entirely when maskOnly is set.
+func Diff(a, b minecraft.Skin, maskOnly bool) image.Image {
+	imgA, imgB := normalizeSkin(a.Image), normalizeSkin(b.Image)
+
+	outIm := image.NewRGBA(imgA.Bounds())
+	for x := 0; x < outIm.Bounds().Dx(); x++ {
+		for y := 0; y < outIm.Bounds().Dy(); y++ {
+			ca, cb := imgA.RGBAAt(x, y), imgB.RGBAAt(x, y)
+			switch {
+			case ca == cb:
+				if !maskOnly {
+					outIm.SetRGBA(x, y, color.RGBA{ca.R / 2, ca.G / 2, ca.B / 2, ca.A / 2})
+				}
+			case cb.A == 0:
+				outIm.Set(x, y, DiffRemoved)
+			default:
+				outIm.Set(x, y, DiffAdded)
+			}
+		}
+	}
+	return outIm
+}
+
+// normalizeSkin copies a skin onto a 64x64 canvas, leaving the bottom half
+// of legacy skins transparent.
+func normalizeSkin(i image.Image) *image.RGBA {
+	outIm := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	if i != nil {
+		draw.Draw(outIm, outIm.Bounds(), i, i.Bounds().Min, draw.Src)
+	}
+	return outIm
+}
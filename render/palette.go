@@ -0,0 +1,146 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+const (
+	// Most times Palette refines its clusters
+	PALETTE_ITERATIONS = 10
+)
+
+type paletteColour struct {
+	r, g, b float64
+	weight  float64
+}
+
+// Palette finds up to k dominant colours of the opaque pixels of img by
+// k-means clustering, most common first.
+func Palette(img image.Image, k int) []color.RGBA {
+	if img == nil || k < 1 {
+		return nil
+	}
+
+	// Skins have few distinct colours, so cluster those weighted by how
+	// often they appear rather than every pixel
+	counts := make(map[color.RGBA]int)
+	bounds := img.Bounds()
+	for x := bounds.Min.X; x < bounds.Max.X; x++ {
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A == 0 {
+				continue
+			}
+			counts[color.RGBA{c.R, c.G, c.B, 255}]++
+		}
+	}
+	if len(counts) == 0 {
+		return nil
+	}
+
+	points := make([]paletteColour, 0, len(counts))
+	for c, n := range counts {
+		points = append(points, paletteColour{float64(c.R), float64(c.G), float64(c.B), float64(n)})
+	}
+	// Map order is random, and the result shouldn't be
+	sort.Slice(points, func(i, j int) bool {
+		a, b := points[i], points[j]
+		if a.weight != b.weight {
+			return a.weight > b.weight
+		}
+		if a.r != b.r {
+			return a.r < b.r
+		}
+		if a.g != b.g {
+			return a.g < b.g
+		}
+		return a.b < b.b
+	})
+	if k > len(points) {
+		k = len(points)
+	}
+
+	centres := initialCentres(points, k)
+	assignment := make([]int, len(points))
+	for iteration := 0; iteration < PALETTE_ITERATIONS; iteration++ {
+		changed := iteration == 0
+		for i, p := range points {
+			if nearest := nearestCentre(p, centres); nearest != assignment[i] {
+				assignment[i] = nearest
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+
+		sums := make([]paletteColour, len(centres))
+		for i, p := range points {
+			s := &sums[assignment[i]]
+			s.r += p.r * p.weight
+			s.g += p.g * p.weight
+			s.b += p.b * p.weight
+			s.weight += p.weight
+		}
+		for i, s := range sums {
+			if s.weight > 0 {
+				centres[i] = paletteColour{s.r / s.weight, s.g / s.weight, s.b / s.weight, s.weight}
+			} else {
+				centres[i].weight = 0
+			}
+		}
+	}
+
+	sort.SliceStable(centres, func(i, j int) bool { return centres[i].weight > centres[j].weight })
+	palette := make([]color.RGBA, 0, len(centres))
+	for _, c := range centres {
+		if c.weight == 0 {
+			continue
+		}
+		palette = append(palette, color.RGBA{uint8(c.r + 0.5), uint8(c.g + 0.5), uint8(c.b + 0.5), 255})
+	}
+	return palette
+}
+
+// initialCentres picks the most common colour, then repeatedly whichever
+// colour is furthest from those already picked, weighted by how common it is.
+func initialCentres(points []paletteColour, k int) []paletteColour {
+	centres := []paletteColour{points[0]}
+	distances := make([]float64, len(points))
+	for i, p := range points {
+		distances[i] = colourDistance(p, points[0])
+	}
+
+	for len(centres) < k {
+		best, bestScore := 0, -1.0
+		for i, p := range points {
+			if score := distances[i] * p.weight; score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		centres = append(centres, points[best])
+		for i, p := range points {
+			if d := colourDistance(p, points[best]); d < distances[i] {
+				distances[i] = d
+			}
+		}
+	}
+	return centres
+}
+
+func nearestCentre(p paletteColour, centres []paletteColour) int {
+	nearest, nearestDistance := 0, -1.0
+	for i, c := range centres {
+		if d := colourDistance(p, c); nearestDistance < 0 || d < nearestDistance {
+			nearest, nearestDistance = i, d
+		}
+	}
+	return nearest
+}
+
+func colourDistance(a, b paletteColour) float64 {
+	dr, dg, db := a.r-b.r, a.g-b.g, a.b-b.b
+	return dr*dr + dg*dg + db*db
+}
@@ -0,0 +1,239 @@
+// Package render draws avatars, bodies and other views of Minecraft skins,
+// and encodes them for serving.
+package render
+
+import (
+	"errors"
+	"fmt"
+	"github.com/applenick/minecraft"
+	"github.com/gen2brain/avif"
+	"github.com/gen2brain/webp"
+	"golang.org/x/image/draw"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+)
+
+const (
+	HEAD_X      = 8
+	HEAD_Y      = 8
+	HEAD_WIDTH  = 8
+	HEAD_HEIGHT = 8
+
+	HELM_X      = 40
+	HELM_Y      = 8
+	HELM_WIDTH  = 8
+	HELM_HEIGHT = 8
+
+	FormatPNG  = "png"
+	FormatJPEG = "jpeg"
+	FormatWebP = "webp"
+	FormatAVIF = "avif"
+
+	DefaultQuality     = 85
+	DefaultWebPQuality = 90
+	DefaultAVIFQuality = 70
+)
+
+var FormatContentTypes = map[string]string{
+	FormatPNG:  "image/png",
+	FormatJPEG: "image/jpeg",
+	FormatWebP: "image/webp",
+	FormatAVIF: "image/avif",
+}
+
+// Head returns the 8x8 front of the head, without the helm.
+func Head(skin minecraft.Skin) (image.Image, error) {
+	return cropImage(skin.Image, image.Rect(HEAD_X, HEAD_Y, HEAD_X+HEAD_WIDTH, HEAD_Y+HEAD_HEIGHT))
+}
+
+// Helm returns the 8x8 front of the head with the helm drawn over it. A helm
+// of a single solid colour is treated as transparent.
+func Helm(skin minecraft.Skin) (image.Image, error) {
+	// check if helm is solid colour - if so, it counts as transparent
+	if isSolidColour(skin.Image, image.Rect(HELM_X, HELM_Y, HELM_X+HELM_WIDTH, HELM_Y+HELM_HEIGHT)) {
+		return Head(skin)
+	}
+
+	headImg, err := Head(skin)
+	if err != nil {
+		return nil, err
+	}
+
+	headImgRGBA := headImg.(*image.RGBA)
+
+	helmImg, err := cropImage(skin.Image, image.Rect(HELM_X, HELM_Y, HELM_X+HELM_WIDTH, HELM_Y+HELM_HEIGHT))
+	if err != nil {
+		return nil, err
+	}
+
+	sr := helmImg.Bounds()
+	draw.Draw(headImgRGBA, sr, helmImg, sr.Min, draw.Over)
+
+	return headImg, nil
+}
+
+// ValidSkinSize checks i has the dimensions of either a legacy 64x32 skin
+// or a modern 64x64 one.
+func ValidSkinSize(i image.Image) bool {
+	if i == nil {
+		return false
+	}
+	size := i.Bounds().Size()
+	return size.X == 64 && (size.Y == 32 || size.Y == 64)
+}
+
+func isSolidColour(i image.Image, r image.Rectangle) bool {
+	baseColour := i.At(r.Min.X, r.Min.Y)
+	for checkX := r.Min.X; checkX < r.Max.X; checkX++ {
+		for checkY := r.Min.Y; checkY < r.Max.Y; checkY++ {
+			if i.At(checkX, checkY) != baseColour {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// WritePNG encodes i as a PNG.
+func WritePNG(w io.Writer, i image.Image) error {
+	return png.Encode(w, i)
+}
+
+// WriteImage encodes i in the given format. quality is ignored for PNG.
+func WriteImage(w io.Writer, i image.Image, format string, quality int) error {
+	switch format {
+	case FormatPNG:
+		return WritePNG(w, i)
+	case FormatJPEG:
+		// JPEG has no alpha channel, so flatten onto white first
+		flat := image.NewRGBA(i.Bounds())
+		draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.ZP, draw.Src)
+		draw.Draw(flat, flat.Bounds(), i, i.Bounds().Min, draw.Over)
+		return jpeg.Encode(w, flat, &jpeg.Options{Quality: quality})
+	case FormatWebP:
+		return WriteWebP(w, i, quality)
+	case FormatAVIF:
+		return WriteAVIF(w, i, quality)
+	}
+	return fmt.Errorf("Unknown image format %q", format)
+}
+
+// WriteWebP encodes i as a lossy WebP of the given quality, from 0 to 100.
+func WriteWebP(w io.Writer, i image.Image, quality int) error {
+	return webp.Encode(w, i, webp.Options{Quality: quality})
+}
+
+// WriteAVIF encodes i as an AVIF of the given quality, from 0 to 100. Chroma
+// isn't subsampled, which would smear the colours of neighbouring pixels.
+func WriteAVIF(w io.Writer, i image.Image, quality int) error {
+	return avif.Encode(w, i, avif.Options{
+		Quality:           quality,
+		QualityAlpha:      quality,
+		Speed:             avif.DefaultSpeed,
+		ChromaSubsampling: image.YCbCrSubsampleRatio444,
+	})
+}
+
+// WriteGIF encodes frames as a looping animated GIF, each shown for the
+// matching delay in 100ths of a second. Mostly transparent pixels become
+// fully transparent, as GIF has no partial transparency.
+func WriteGIF(w io.Writer, frames []image.Image, delays []int) error {
+	pal := append(color.Palette{color.Transparent}, palette.Plan9[:255]...)
+
+	anim := &gif.GIF{Delay: delays}
+	for _, frame := range frames {
+		bounds := frame.Bounds()
+		paletted := image.NewPaletted(bounds, pal)
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+				c := frame.At(x, y)
+				if _, _, _, a := c.RGBA(); a < 0x8000 {
+					continue // already index 0, transparent
+				}
+				paletted.SetColorIndex(x, y, uint8(pal[1:].Index(c)+1))
+			}
+		}
+		anim.Image = append(anim.Image, paletted)
+		// Clear each frame before the next, otherwise they pile up
+		anim.Disposal = append(anim.Disposal, gif.DisposalBackground)
+	}
+	return gif.EncodeAll(w, anim)
+}
+
+// Filters are the scalers Resize can use, by name. Nearest neighbour keeps
+// skins' pixels crisp, the others smooth them.
+var Filters = map[string]draw.Interpolator{
+	"nearest":  draw.NearestNeighbor,
+	"bilinear": draw.BiLinear,
+	"lanczos3": &draw.Kernel{Support: 3, At: lanczos3},
+}
+
+// Resize scales img with the named filter from Filters, or nearest neighbour
+// if there is no such filter. A zero width or height is worked out from the
+// other to keep the aspect ratio.
+func Resize(width, height uint, img image.Image, filter string) image.Image {
+	bounds := img.Bounds()
+	if width == 0 {
+		width = uint(bounds.Dx()) * height / uint(bounds.Dy())
+	} else if height == 0 {
+		height = uint(bounds.Dy()) * width / uint(bounds.Dx())
+	}
+
+	scaler, ok := Filters[filter]
+	if !ok {
+		scaler = draw.NearestNeighbor
+	}
+
+	outIm := image.NewRGBA(image.Rect(0, 0, int(width), int(height)))
+	scaler.Scale(outIm, outIm.Bounds(), img, bounds, draw.Src, nil)
+	return outIm
+}
+
+// Pad surrounds img with n pixels of transparency on every side.
+func Pad(img image.Image, n int) image.Image {
+	bounds := img.Bounds()
+	outIm := image.NewRGBA(image.Rect(0, 0, bounds.Dx()+2*n, bounds.Dy()+2*n))
+	draw.Draw(outIm, outIm.Bounds().Inset(n), img, bounds.Min, draw.Src)
+	return outIm
+}
+
+// Background composites img over a solid colour, respecting its alpha.
+func Background(img image.Image, c color.Color) image.Image {
+	bounds := img.Bounds()
+	outIm := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(outIm, outIm.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+	draw.Draw(outIm, outIm.Bounds(), img, bounds.Min, draw.Over)
+	return outIm
+}
+
+func lanczos3(t float64) float64 {
+	if t == 0 {
+		return 1
+	} else if t <= -3 || t >= 3 {
+		return 0
+	}
+	pt := math.Pi * t
+	return 3 * math.Sin(pt) * math.Sin(pt/3) / (pt * pt)
+}
+
+func cropImage(i image.Image, d image.Rectangle) (image.Image, error) {
+	bounds := i.Bounds()
+	if bounds.Min.X > d.Min.X || bounds.Min.Y > d.Min.Y || bounds.Max.X < d.Max.X || bounds.Max.Y < d.Max.Y {
+		return nil, errors.New("Bounds invalid for crop")
+	}
+
+	dims := d.Size()
+	outIm := image.NewRGBA(image.Rect(0, 0, dims.X, dims.Y))
+	for x := 0; x < dims.X; x++ {
+		for y := 0; y < dims.Y; y++ {
+			outIm.Set(x, y, i.At(d.Min.X+x, d.Min.Y+y))
+		}
+	}
+	return outIm, nil
+}
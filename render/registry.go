@@ -0,0 +1,63 @@
+package render
+
+import (
+	"fmt"
+	"github.com/applenick/minecraft"
+	"image"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// RendererFunc draws an image from a skin, like Head or Body.
+type RendererFunc func(minecraft.Skin) (image.Image, error)
+
+var validRendererName = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]RendererFunc)
+)
+
+// RegisterRenderer adds a render type that is served at /name/{username} and
+// /name/{username}/{size}, just like the built-in ones. It is meant to be
+// called from the init function of the package providing the render, and
+// fails if the name is already registered.
+func RegisterRenderer(name string, fn func(minecraft.Skin) (image.Image, error)) error {
+	if !validRendererName.MatchString(name) {
+		return fmt.Errorf("Invalid renderer name %q", name)
+	}
+	if fn == nil {
+		return fmt.Errorf("Renderer %q has no render function", name)
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[name]; ok {
+		return fmt.Errorf("Renderer %q is already registered", name)
+	}
+	registry[name] = fn
+	return nil
+}
+
+// RegisteredRenderers returns the names of every registered render type,
+// sorted.
+func RegisteredRenderers() []string {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Renderer returns the registered render type with the given name.
+func Renderer(name string) (RendererFunc, bool) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	fn, ok := registry[name]
+	return fn, ok
+}
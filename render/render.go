@@ -0,0 +1,479 @@
+package render
+
+import (
+	"errors"
+	"fmt"
+	"github.com/applenick/minecraft"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+const (
+	BODY_WIDTH  = 16
+	BODY_HEIGHT = 32
+	BUST_HEIGHT = 20 // head and torso, cut off at the waist
+
+	TORSO_X      = 20
+	TORSO_Y      = 20
+	TORSO_WIDTH  = 8
+	TORSO_HEIGHT = 12
+
+	RARM_X      = 44
+	RARM_Y      = 20
+	LARM_X      = 36
+	LARM_Y      = 52
+	ARM_WIDTH   = 4
+	SLIM_WIDTH  = 3 // width of the slim model's arms
+	ARM_HEIGHT  = 12
+	RLEG_X      = 4
+	RLEG_Y      = 20
+	LLEG_X      = 20
+	LLEG_Y      = 52
+	LEG_WIDTH   = 4
+	LEG_HEIGHT  = 12
+	LAYER_DEPTH = 16 // offset from a base part to its overlay on 64x64 skins
+
+	LARM_OVERLAY_X = 52
+	LLEG_OVERLAY_X = 4
+
+	HEAD_TOP_X  = 8
+	HEAD_TOP_Y  = 0
+	HEAD_SIDE_X = 16 // the head's left side, which faces the viewer's right
+	HEAD_SIDE_Y = 8
+	HELM_OFFSET = HELM_X - HEAD_X
+
+	// Each texel of the head becomes a 2:1 diamond ISO_SCALE pixels high,
+	// so the whole cube fits in a square of 32*ISO_SCALE pixels.
+	ISO_SCALE = 4
+	ISO_SIZE  = 32 * ISO_SCALE
+
+	CAPE_WIDTH  = 64
+	CAPE_HEIGHT = 32
+
+	// Rotating heads are viewed from this far above, in radians
+	ROTATE_PITCH = math.Pi / 6
+	// How long a full turn of a rotating head takes, in 100ths of a second
+	ROTATE_DURATION = 200
+)
+
+// Body renders the front of the whole character: head, torso, arms and
+// legs, each with their overlay layer drawn on top. Slim skins are drawn
+// with their narrower arms.
+func Body(skin minecraft.Skin) (image.Image, error) {
+	helmImg, err := Helm(skin)
+	if err != nil {
+		return nil, err
+	}
+
+	bodyImg := image.NewRGBA(image.Rect(0, 0, BODY_WIDTH, BODY_HEIGHT))
+	draw.Draw(bodyImg, image.Rect(4, 0, 12, 8), helmImg, image.ZP, draw.Src)
+
+	hasOverlays := SkinFormat(skin.Image) == "modern"
+
+	armWidth := ARM_WIDTH
+	if DetectModel(skin) == "slim" {
+		armWidth = SLIM_WIDTH
+	}
+	// The right arm hangs against the torso, so a narrow one starts further in
+	rarmAt := image.Pt(4-armWidth, 8)
+
+	// Torso
+	drawPart(bodyImg, skin.Image, image.Rect(TORSO_X, TORSO_Y, TORSO_X+TORSO_WIDTH, TORSO_Y+TORSO_HEIGHT), image.Pt(4, 8), false)
+	// Right arm and leg, which appear on the left of the render
+	drawPart(bodyImg, skin.Image, image.Rect(RARM_X, RARM_Y, RARM_X+armWidth, RARM_Y+ARM_HEIGHT), rarmAt, false)
+	drawPart(bodyImg, skin.Image, image.Rect(RLEG_X, RLEG_Y, RLEG_X+LEG_WIDTH, RLEG_Y+LEG_HEIGHT), image.Pt(4, 20), false)
+
+	if hasOverlays {
+		drawPart(bodyImg, skin.Image, image.Rect(LARM_X, LARM_Y, LARM_X+armWidth, LARM_Y+ARM_HEIGHT), image.Pt(12, 8), false)
+		drawPart(bodyImg, skin.Image, image.Rect(LLEG_X, LLEG_Y, LLEG_X+LEG_WIDTH, LLEG_Y+LEG_HEIGHT), image.Pt(8, 20), false)
+
+		drawOverlay(bodyImg, skin.Image, image.Rect(TORSO_X, TORSO_Y+LAYER_DEPTH, TORSO_X+TORSO_WIDTH, TORSO_Y+LAYER_DEPTH+TORSO_HEIGHT), image.Pt(4, 8))
+		drawOverlay(bodyImg, skin.Image, image.Rect(RARM_X, RARM_Y+LAYER_DEPTH, RARM_X+armWidth, RARM_Y+LAYER_DEPTH+ARM_HEIGHT), rarmAt)
+		drawOverlay(bodyImg, skin.Image, image.Rect(RLEG_X, RLEG_Y+LAYER_DEPTH, RLEG_X+LEG_WIDTH, RLEG_Y+LAYER_DEPTH+LEG_HEIGHT), image.Pt(4, 20))
+		drawOverlay(bodyImg, skin.Image, image.Rect(LARM_OVERLAY_X, LARM_Y, LARM_OVERLAY_X+armWidth, LARM_Y+ARM_HEIGHT), image.Pt(12, 8))
+		drawOverlay(bodyImg, skin.Image, image.Rect(LLEG_OVERLAY_X, LLEG_Y, LLEG_OVERLAY_X+LEG_WIDTH, LLEG_Y+LEG_HEIGHT), image.Pt(8, 20))
+	} else {
+		// Legacy skins only have one arm and leg, mirrored for the other side
+		drawPart(bodyImg, skin.Image, image.Rect(RARM_X, RARM_Y, RARM_X+ARM_WIDTH, RARM_Y+ARM_HEIGHT), image.Pt(12, 8), true)
+		drawPart(bodyImg, skin.Image, image.Rect(RLEG_X, RLEG_Y, RLEG_X+LEG_WIDTH, RLEG_Y+LEG_HEIGHT), image.Pt(8, 20), true)
+	}
+
+	return bodyImg, nil
+}
+
+// BodyOverlay renders just the overlay layer of the front of the character,
+// the helm, jacket, sleeves and trousers, laid out as in Body with the base
+// layer left transparent. Legacy skins only have the helm.
+func BodyOverlay(skin minecraft.Skin) (image.Image, error) {
+	if !image.Rect(0, 0, HELM_X+HELM_WIDTH, HELM_Y+HELM_HEIGHT).In(skin.Image.Bounds()) {
+		return nil, errors.New("Bounds invalid for overlay render")
+	}
+
+	overlayImg := image.NewRGBA(image.Rect(0, 0, BODY_WIDTH, BODY_HEIGHT))
+	drawOverlay(overlayImg, skin.Image, image.Rect(HELM_X, HELM_Y, HELM_X+HELM_WIDTH, HELM_Y+HELM_HEIGHT), image.Pt(4, 0))
+	if SkinFormat(skin.Image) == "legacy" {
+		return overlayImg, nil
+	}
+
+	armWidth := ARM_WIDTH
+	if DetectModel(skin) == "slim" {
+		armWidth = SLIM_WIDTH
+	}
+
+	drawOverlay(overlayImg, skin.Image, image.Rect(TORSO_X, TORSO_Y+LAYER_DEPTH, TORSO_X+TORSO_WIDTH, TORSO_Y+LAYER_DEPTH+TORSO_HEIGHT), image.Pt(4, 8))
+	drawOverlay(overlayImg, skin.Image, image.Rect(RARM_X, RARM_Y+LAYER_DEPTH, RARM_X+armWidth, RARM_Y+LAYER_DEPTH+ARM_HEIGHT), image.Pt(4-armWidth, 8))
+	drawOverlay(overlayImg, skin.Image, image.Rect(RLEG_X, RLEG_Y+LAYER_DEPTH, RLEG_X+LEG_WIDTH, RLEG_Y+LAYER_DEPTH+LEG_HEIGHT), image.Pt(4, 20))
+	drawOverlay(overlayImg, skin.Image, image.Rect(LARM_OVERLAY_X, LARM_Y, LARM_OVERLAY_X+armWidth, LARM_Y+ARM_HEIGHT), image.Pt(12, 8))
+	drawOverlay(overlayImg, skin.Image, image.Rect(LLEG_OVERLAY_X, LLEG_Y, LLEG_OVERLAY_X+LEG_WIDTH, LLEG_Y+LEG_HEIGHT), image.Pt(8, 20))
+
+	return overlayImg, nil
+}
+
+// Bust renders the front of the head, torso and arms, cut off at the
+// waist.
+func Bust(skin minecraft.Skin) (image.Image, error) {
+	bodyImg, err := Body(skin)
+	if err != nil {
+		return nil, err
+	}
+	return cropImage(bodyImg, image.Rect(0, 0, BODY_WIDTH, BUST_HEIGHT))
+}
+
+// SkinFormat tells "legacy" 64x32 skins, which only have a helm overlay and
+// one arm and leg, from "modern" 64x64 ones with overlays for every part and
+// separate left limbs.
+func SkinFormat(img image.Image) string {
+	if img.Bounds().Dy() >= 64 {
+		return "modern"
+	}
+	return "legacy"
+}
+
+// DetectModel guesses whether a skin is for the classic (Steve) model or the
+// slim (Alex) one. Slim arms are only three pixels wide, so their faces pack
+// two columns tighter and the last columns of a classic arm's back face are
+// left transparent. Legacy skins are always classic.
+func DetectModel(skin minecraft.Skin) string {
+	if skin.Image == nil || SkinFormat(skin.Image) == "legacy" {
+		return "classic"
+	}
+
+	_, _, _, a := skin.Image.At(RARM_X+ARM_WIDTH+6, RARM_Y).RGBA()
+	if a == 0 {
+		return "slim"
+	}
+	return "classic"
+}
+
+// Cape returns the cape texture, or a transparent texture of the same size
+// if there is no cape.
+func Cape(cape minecraft.Skin) (image.Image, error) {
+	if cape.Image == nil {
+		return image.NewRGBA(image.Rect(0, 0, CAPE_WIDTH, CAPE_HEIGHT)), nil
+	}
+	return cropImage(cape.Image, cape.Image.Bounds())
+}
+
+// drawPart copies the region r of the skin to dst at the point at,
+// optionally mirroring it horizontally.
+func drawPart(dst draw.Image, skin image.Image, r image.Rectangle, at image.Point, mirror bool) {
+	part, err := cropImage(skin, r)
+	if err != nil {
+		return
+	}
+	if mirror {
+		part = flipHorizontal(part)
+	}
+	draw.Draw(dst, part.Bounds().Add(at), part, image.ZP, draw.Src)
+}
+
+// drawOverlay composites the region r of the skin over whatever is already
+// in dst at the point at.
+func drawOverlay(dst draw.Image, skin image.Image, r image.Rectangle, at image.Point) {
+	part, err := cropImage(skin, r)
+	if err != nil {
+		return
+	}
+	draw.Draw(dst, part.Bounds().Add(at), part, image.ZP, draw.Over)
+}
+
+func flipHorizontal(i image.Image) image.Image {
+	bounds := i.Bounds()
+	outIm := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for x := 0; x < bounds.Dx(); x++ {
+		for y := 0; y < bounds.Dy(); y++ {
+			outIm.Set(bounds.Dx()-1-x, y, i.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return outIm
+}
+
+// HeadFaces gives the position in the skin of each face of the head. The
+// helm for each face is HELM_OFFSET pixels to the right.
+var HeadFaces = map[string]image.Point{
+	"front":  image.Pt(8, 8),
+	"back":   image.Pt(24, 8),
+	"right":  image.Pt(0, 8),
+	"left":   image.Pt(16, 8),
+	"top":    image.Pt(8, 0),
+	"bottom": image.Pt(16, 0),
+}
+
+// HelmFace returns one face of the head with its helm drawn on top.
+func HelmFace(skin minecraft.Skin, face string) (image.Image, error) {
+	pos, ok := HeadFaces[face]
+	if !ok {
+		return nil, fmt.Errorf("Unknown face %q", face)
+	}
+
+	faceRect := image.Rect(pos.X, pos.Y, pos.X+HEAD_WIDTH, pos.Y+HEAD_HEIGHT)
+	faceImg, err := cropImage(skin.Image, faceRect)
+	if err != nil {
+		return nil, err
+	}
+
+	helmRect := faceRect.Add(image.Pt(HELM_OFFSET, 0))
+	if !isSolidColour(skin.Image, helmRect) {
+		drawOverlay(faceImg.(draw.Image), skin.Image, helmRect, image.ZP)
+	}
+	return faceImg, nil
+}
+
+// HeadBase returns just the base layer of the front of the head.
+func HeadBase(skin minecraft.Skin) (image.Image, error) {
+	return Head(skin)
+}
+
+// HeadOverlay returns just the overlay (helm) layer of the front of the
+// head, including any transparency, without the head underneath.
+func HeadOverlay(skin minecraft.Skin) (image.Image, error) {
+	return HelmOverlay(skin)
+}
+
+// HelmOverlay draws only the helm layer of the front of the head onto a
+// transparent canvas, for compositing over a base drawn elsewhere.
+func HelmOverlay(skin minecraft.Skin) (image.Image, error) {
+	helm, err := cropImage(skin.Image, image.Rect(HELM_X, HELM_Y, HELM_X+HELM_WIDTH, HELM_Y+HELM_HEIGHT))
+	if err != nil {
+		return nil, err
+	}
+
+	canvas := image.NewNRGBA(image.Rect(0, 0, HELM_WIDTH, HELM_HEIGHT))
+	draw.Draw(canvas, canvas.Bounds(), helm, helm.Bounds().Min, draw.Src)
+	return canvas, nil
+}
+
+// HeadBack returns the back of the head, without the helm.
+func HeadBack(skin minecraft.Skin) (image.Image, error) {
+	pos := HeadFaces["back"]
+	return cropImage(skin.Image, image.Rect(pos.X, pos.Y, pos.X+HEAD_WIDTH, pos.Y+HEAD_HEIGHT))
+}
+
+// HelmBack returns the back of the head with the helm drawn over it.
+func HelmBack(skin minecraft.Skin) (image.Image, error) {
+	return HelmFace(skin, "back")
+}
+
+// IsoHead renders the head as an isometric cube showing its top, front
+// and left side (on the viewer's right), with the helm drawn over each face.
+func IsoHead(skin minecraft.Skin) (image.Image, error) {
+	if !image.Rect(0, 0, HELM_X+HELM_WIDTH, HELM_Y+HELM_HEIGHT).In(skin.Image.Bounds()) {
+		return nil, errors.New("Bounds invalid for isometric render")
+	}
+
+	// a solid colour helm counts as transparent, as in Helm
+	showHelm := !isSolidColour(skin.Image, image.Rect(HELM_X, HELM_Y, HELM_X+HELM_WIDTH, HELM_Y+HELM_HEIGHT))
+
+	outIm := image.NewRGBA(image.Rect(0, 0, ISO_SIZE, ISO_SIZE))
+	for px := 0; px < ISO_SIZE; px++ {
+		for py := 0; py < ISO_SIZE; py++ {
+			texel, shade, ok := isoTexelAt((float64(px)+0.5)/ISO_SCALE, (float64(py)+0.5)/ISO_SCALE)
+			if !ok {
+				continue
+			}
+
+			c := skin.Image.At(texel.X, texel.Y)
+			if showHelm {
+				c = blendOver(c, skin.Image.At(texel.X+HELM_OFFSET, texel.Y))
+			}
+			outIm.Set(px, py, shadeColour(c, shade))
+		}
+	}
+	return outIm, nil
+}
+
+// RotatingHead renders frames views of the head, each turned a further
+// 360/frames degrees, along with how long each should be shown for.
+func RotatingHead(skin minecraft.Skin, frames int) ([]image.Image, []int, error) {
+	if frames < 1 {
+		return nil, nil, errors.New("A rotating head needs at least one frame")
+	}
+	if !image.Rect(0, 0, HELM_X+HELM_WIDTH, HELM_Y+HELM_HEIGHT).In(skin.Image.Bounds()) {
+		return nil, nil, errors.New("Bounds invalid for rotating render")
+	}
+	showHelm := !isSolidColour(skin.Image, image.Rect(HELM_X, HELM_Y, HELM_X+HELM_WIDTH, HELM_Y+HELM_HEIGHT))
+
+	images := make([]image.Image, frames)
+	delays := make([]int, frames)
+	for i := range images {
+		images[i] = renderTurnedHead(skin.Image, 2*math.Pi*float64(i)/float64(frames), showHelm)
+		delays[i] = ROTATE_DURATION / frames
+	}
+	return images, delays, nil
+}
+
+// renderTurnedHead draws the head turned yaw radians about its vertical
+// axis, seen from slightly above. Each pixel casts a ray back into the 8x8x8 head, centred on
+// the origin, and takes its colour from the face the ray enters by.
+func renderTurnedHead(skin image.Image, yaw float64, showHelm bool) image.Image {
+	sinY, cosY := math.Sincos(yaw)
+	sinP, cosP := math.Sincos(ROTATE_PITCH)
+	// Undo the pitch, then the yaw, to take a direction from the viewer's
+	// space back into the head's
+	toHead := func(x, y, z float64) [3]float64 {
+		y, z = y*cosP+z*sinP, -y*sinP+z*cosP
+		return [3]float64{x*cosY - z*sinY, y, x*sinY + z*cosY}
+	}
+
+	scale := float64(ISO_SIZE) / 16
+	dir := toHead(0, 0, -1)
+	outIm := image.NewRGBA(image.Rect(0, 0, ISO_SIZE, ISO_SIZE))
+	for px := 0; px < ISO_SIZE; px++ {
+		for py := 0; py < ISO_SIZE; py++ {
+			origin := toHead((float64(px)+0.5)/scale-8, 8-(float64(py)+0.5)/scale, 16)
+
+			texel, shade, ok := turnedTexelAt(origin, dir)
+			if !ok {
+				continue
+			}
+
+			c := skin.At(texel.X, texel.Y)
+			if showHelm {
+				c = blendOver(c, skin.At(texel.X+HELM_OFFSET, texel.Y))
+			}
+			outIm.Set(px, py, shadeColour(c, shade))
+		}
+	}
+	return outIm
+}
+
+// turnedTexelAt finds where the ray from origin along dir enters the head,
+// returning the texel there and how much light that face receives.
+func turnedTexelAt(origin, dir [3]float64) (image.Point, float64, bool) {
+	tEnter, tExit := math.Inf(-1), math.Inf(1)
+	axis := -1
+	for i := 0; i < 3; i++ {
+		if dir[i] == 0 {
+			if origin[i] < -4 || origin[i] > 4 {
+				return image.ZP, 0, false
+			}
+			continue
+		}
+		t1, t2 := (-4-origin[i])/dir[i], (4-origin[i])/dir[i]
+		if t1 > t2 {
+			t1, t2 = t2, t1
+		}
+		if t1 > tEnter {
+			tEnter, axis = t1, i
+		}
+		tExit = math.Min(tExit, t2)
+	}
+	if axis < 0 || tEnter > tExit {
+		return image.ZP, 0, false
+	}
+
+	// Position within the head in texels, from its right, bottom and back
+	x := texelIndex(origin[0] + tEnter*dir[0] + 4)
+	y := texelIndex(origin[1] + tEnter*dir[1] + 4)
+	z := texelIndex(origin[2] + tEnter*dir[2] + 4)
+
+	switch {
+	case axis == 1 && dir[1] < 0: // top
+		return image.Pt(HEAD_TOP_X+x, HEAD_TOP_Y+z), 1, true
+	case axis == 1: // bottom
+		return image.Pt(HeadFaces["bottom"].X+x, HeadFaces["bottom"].Y+7-z), 0.6, true
+	case axis == 2 && dir[2] < 0: // front
+		return image.Pt(HEAD_X+x, HEAD_Y+7-y), 0.9, true
+	case axis == 2: // back
+		return image.Pt(HeadFaces["back"].X+7-x, HeadFaces["back"].Y+7-y), 0.8, true
+	case dir[0] > 0: // right
+		return image.Pt(HeadFaces["right"].X+z, HeadFaces["right"].Y+7-y), 0.75, true
+	default: // left
+		return image.Pt(HEAD_SIDE_X+7-z, HEAD_SIDE_Y+7-y), 0.75, true
+	}
+}
+
+func texelIndex(f float64) int {
+	i := int(math.Floor(f))
+	if i < 0 {
+		return 0
+	} else if i > 7 {
+		return 7
+	}
+	return i
+}
+
+// isoTexelAt maps a point on the isometric render, in texel units, back to
+// the texel of the skin's head which is drawn there and how much light that
+// face receives.
+//
+// The cube's front-bottom-left corner sits at (0, 24). Moving one texel
+// across the front face moves (2, 1) on screen, one texel back along the
+// side moves (2, -1) and one texel up moves (0, -2).
+func isoTexelAt(x, y float64) (image.Point, float64, bool) {
+	y -= 24
+
+	// front face
+	across, up := x/2, (x/2-y)/2
+	if inFace(across, up) {
+		return image.Pt(HEAD_X+int(across), HEAD_Y+7-int(up)), 0.9, true
+	}
+
+	// side face
+	back := (x - 16) / 2
+	up = (-back - (y - 8)) / 2
+	if inFace(back, up) {
+		return image.Pt(HEAD_SIDE_X+int(back), HEAD_SIDE_Y+7-int(up)), 0.75, true
+	}
+
+	// top face
+	across, back = (x/2+y+16)/2, (x/2-y-16)/2
+	if inFace(across, back) {
+		return image.Pt(HEAD_TOP_X+int(across), HEAD_TOP_Y+7-int(back)), 1, true
+	}
+
+	return image.ZP, 0, false
+}
+
+func inFace(a, b float64) bool {
+	return a >= 0 && a < 8 && b >= 0 && b < 8
+}
+
+// blendOver composites src over dst.
+func blendOver(dst, src color.Color) color.Color {
+	sr, sg, sb, sa := src.RGBA()
+	if sa == 0xffff {
+		return src
+	}
+	dr, dg, db, da := dst.RGBA()
+	inv := 0xffff - sa
+	return color.RGBA64{
+		R: uint16(sr + dr*inv/0xffff),
+		G: uint16(sg + dg*inv/0xffff),
+		B: uint16(sb + db*inv/0xffff),
+		A: uint16(sa + da*inv/0xffff),
+	}
+}
+
+// shadeColour darkens c to light the faces of a render differently.
+func shadeColour(c color.Color, shade float64) color.Color {
+	r, g, b, a := c.RGBA()
+	return color.RGBA64{
+		R: uint16(math.Floor(float64(r) * shade)),
+		G: uint16(math.Floor(float64(g) * shade)),
+		B: uint16(math.Floor(float64(b) * shade)),
+		A: uint16(a),
+	}
+}
@@ -0,0 +1,241 @@
+package render
+
+import (
+	"bytes"
+	"github.com/applenick/minecraft"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+var (
+	red   = color.RGBA{255, 0, 0, 255}
+	green = color.RGBA{0, 255, 0, 255}
+	blue  = color.RGBA{0, 0, 255, 255}
+)
+
+// newSkin returns a skin of the given height with each rectangle filled in
+// its colour and everything else transparent.
+func newSkin(height int, fills map[image.Rectangle]color.Color) minecraft.Skin {
+	img := image.NewRGBA(image.Rect(0, 0, 64, height))
+	for r, c := range fills {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				img.Set(x, y, c)
+			}
+		}
+	}
+	return minecraft.Skin{Image: img}
+}
+
+func rgba(c color.Color) color.RGBA {
+	return color.RGBAModel.Convert(c).(color.RGBA)
+}
+
+var headRect = image.Rect(HEAD_X, HEAD_Y, HEAD_X+HEAD_WIDTH, HEAD_Y+HEAD_HEIGHT)
+var helmRect = image.Rect(HELM_X, HELM_Y, HELM_X+HELM_WIDTH, HELM_Y+HELM_HEIGHT)
+
+func TestHead(t *testing.T) {
+	tests := []struct {
+		name    string
+		skin    minecraft.Skin
+		wantErr bool
+	}{
+		{"modern", newSkin(64, map[image.Rectangle]color.Color{headRect: red}), false},
+		{"legacy", newSkin(32, map[image.Rectangle]color.Color{headRect: red}), false},
+		{"too small", minecraft.Skin{Image: image.NewRGBA(image.Rect(0, 0, 8, 8))}, true},
+	}
+
+	for _, test := range tests {
+		img, err := Head(test.skin)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		if size := img.Bounds().Size(); size != image.Pt(HEAD_WIDTH, HEAD_HEIGHT) {
+			t.Errorf("%s: expected an 8x8 head, got %v", test.name, size)
+		}
+		if c := rgba(img.At(4, 4)); c != red {
+			t.Errorf("%s: expected the head's colour, got %v", test.name, c)
+		}
+	}
+}
+
+func TestHelm(t *testing.T) {
+	patterned := newSkin(64, map[image.Rectangle]color.Color{
+		headRect: red,
+		image.Rect(HELM_X, HELM_Y, HELM_X+4, HELM_Y+HELM_HEIGHT): green,
+	})
+
+	tests := []struct {
+		name  string
+		skin  minecraft.Skin
+		left  color.RGBA
+		right color.RGBA
+	}{
+		{"no helm", newSkin(64, map[image.Rectangle]color.Color{headRect: red}), red, red},
+		{"solid helm is ignored", newSkin(64, map[image.Rectangle]color.Color{headRect: red, helmRect: blue}), red, red},
+		{"helm over head", patterned, green, red},
+	}
+
+	for _, test := range tests {
+		img, err := Helm(test.skin)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		if c := rgba(img.At(1, 4)); c != test.left {
+			t.Errorf("%s: expected %v on the left, got %v", test.name, test.left, c)
+		}
+		if c := rgba(img.At(6, 4)); c != test.right {
+			t.Errorf("%s: expected %v on the right, got %v", test.name, test.right, c)
+		}
+	}
+}
+
+func TestBody(t *testing.T) {
+	// All four sides of the right arm, which is how DetectModel tells them apart
+	rarm := image.Rect(RARM_X-ARM_WIDTH, RARM_Y, RARM_X+3*ARM_WIDTH, RARM_Y+ARM_HEIGHT)
+	slimRarm := image.Rect(RARM_X-ARM_WIDTH, RARM_Y, RARM_X+SLIM_WIDTH+ARM_WIDTH+SLIM_WIDTH, RARM_Y+ARM_HEIGHT)
+	larm := image.Rect(LARM_X, LARM_Y, LARM_X+ARM_WIDTH, LARM_Y+ARM_HEIGHT)
+
+	tests := []struct {
+		name     string
+		skin     minecraft.Skin
+		outerArm bool // whether the outermost column of the right arm is drawn
+		leftArm  bool // whether the left arm is drawn
+	}{
+		{"classic", newSkin(64, map[image.Rectangle]color.Color{headRect: red, rarm: green, larm: blue}), true, true},
+		{"slim", newSkin(64, map[image.Rectangle]color.Color{headRect: red, slimRarm: green, larm: blue}), false, true},
+		{"legacy mirrors the right arm", newSkin(32, map[image.Rectangle]color.Color{headRect: red, rarm: green}), true, true},
+	}
+
+	for _, test := range tests {
+		img, err := Body(test.skin)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		if size := img.Bounds().Size(); size != image.Pt(BODY_WIDTH, BODY_HEIGHT) {
+			t.Errorf("%s: expected a %dx%d body, got %v", test.name, BODY_WIDTH, BODY_HEIGHT, size)
+		}
+		if c := rgba(img.At(6, 4)); c != red {
+			t.Errorf("%s: expected the head at the top, got %v", test.name, c)
+		}
+		if _, _, _, a := img.At(0, 10).RGBA(); (a != 0) != test.outerArm {
+			t.Errorf("%s: expected outer arm column drawn to be %t", test.name, test.outerArm)
+		}
+		if _, _, _, a := img.At(13, 10).RGBA(); (a != 0) != test.leftArm {
+			t.Errorf("%s: expected left arm drawn to be %t", test.name, test.leftArm)
+		}
+	}
+}
+
+func TestResize(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 8, 4))
+	for x := 0; x < 8; x++ {
+		for y := 0; y < 4; y++ {
+			src.Set(x, y, red)
+		}
+	}
+
+	tests := []struct {
+		width, height uint
+		filter        string
+		want          image.Point
+	}{
+		{16, 8, "nearest", image.Pt(16, 8)},
+		{16, 0, "nearest", image.Pt(16, 8)},
+		{0, 2, "bilinear", image.Pt(4, 2)},
+		{24, 12, "lanczos3", image.Pt(24, 12)},
+		{16, 8, "unknown", image.Pt(16, 8)},
+	}
+
+	for _, test := range tests {
+		img := Resize(test.width, test.height, src, test.filter)
+		if size := img.Bounds().Size(); size != test.want {
+			t.Errorf("Resize(%d, %d, %s): expected %v, got %v", test.width, test.height, test.filter, test.want, size)
+		}
+		if c := rgba(img.At(1, 1)); c != red {
+			t.Errorf("Resize(%d, %d, %s): expected a solid image to stay %v, got %v", test.width, test.height, test.filter, red, c)
+		}
+	}
+}
+
+func TestWritePNG(t *testing.T) {
+	transparent := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	solid := image.NewRGBA(image.Rect(0, 0, 8, 2))
+	for x := 0; x < 8; x++ {
+		solid.Set(x, 0, blue)
+		solid.Set(x, 1, blue)
+	}
+
+	tests := []struct {
+		name string
+		img  *image.RGBA
+	}{
+		{"transparent", transparent},
+		{"solid", solid},
+	}
+
+	for _, test := range tests {
+		buf := new(bytes.Buffer)
+		err := WritePNG(buf, test.img)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+
+		decoded, err := png.Decode(buf)
+		if err != nil {
+			t.Errorf("%s: output doesn't decode: %s", test.name, err)
+			continue
+		}
+		if decoded.Bounds() != test.img.Bounds() {
+			t.Errorf("%s: expected bounds %v, got %v", test.name, test.img.Bounds(), decoded.Bounds())
+		}
+		if c, want := rgba(decoded.At(0, 0)), rgba(test.img.At(0, 0)); c != want {
+			t.Errorf("%s: expected %v, got %v", test.name, want, c)
+		}
+	}
+}
+
+func TestPad(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 8, 4))
+	for x := 0; x < 8; x++ {
+		for y := 0; y < 4; y++ {
+			src.Set(x, y, red)
+		}
+	}
+
+	tests := []struct {
+		n    int
+		want image.Point
+	}{
+		{0, image.Pt(8, 4)},
+		{1, image.Pt(10, 6)},
+		{32, image.Pt(72, 68)},
+	}
+
+	for _, test := range tests {
+		img := Pad(src, test.n)
+		if size := img.Bounds().Size(); size != test.want {
+			t.Errorf("Pad(%d): expected %v, got %v", test.n, test.want, size)
+		}
+		if c := rgba(img.At(test.n, test.n)); c != red {
+			t.Errorf("Pad(%d): expected the image inside the padding, got %v", test.n, c)
+		}
+		if test.n > 0 {
+			if _, _, _, a := img.At(test.n-1, test.n-1).RGBA(); a != 0 {
+				t.Errorf("Pad(%d): expected transparent padding", test.n)
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	RequestIDHeader = "X-Request-ID"
+	// Longest request ID we'll accept from a client before making our own
+	MaxRequestIDLength = 128
+)
+
+type requestIDKey struct{}
+
+// RequestIDMiddleware tags each request with the client's X-Request-ID, or
+// a new UUID if it didn't send one, so that the request can be followed
+// through our logs and on to Mojang.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(withRequestID(r.Context(), id)))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > MaxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random version 4 UUID.
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the ID of the request ctx belongs to, or ""
+// if it doesn't belong to one.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// detachContext returns a context carrying ctx's request ID that isn't
+// cancelled along with it, for work shared with other requests.
+func detachContext(ctx context.Context) context.Context {
+	return withRequestID(context.Background(), RequestIDFromContext(ctx))
+}
+
+// mojangGet makes a GET request to Mojang, passing on the request ID.
+func mojangGet(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if id := RequestIDFromContext(ctx); id != "" {
+		req.Header.Set(RequestIDHeader, id)
+	}
+
+	start := time.Now()
+	resp, err := mojangClient.Do(req)
+	if err != nil {
+		debug(ctx, "Mojang request failed", "url", url, "error", err, "ms", timeBetween(start, time.Now()))
+	} else {
+		debug(ctx, "Mojang request", "url", url, "status", resp.StatusCode, "ms", timeBetween(start, time.Now()))
+	}
+	return resp, err
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"github.com/applenick/appletar/render"
+	"net/http"
+	"strings"
+)
+
+// robotsDisallowed are the routes crawlers are asked to stay out of, as every
+// player they find leads to thousands more images.
+var robotsDisallowed = []string{
+	"avatar", "helm", "body", "bust", "isometric", "overlay", "armor", "cape",
+	"skin", "download", "diff", "v2",
+}
+
+// defaultRobotsTxt generates the robots.txt served when the static directory
+// doesn't have one.
+func defaultRobotsTxt() string {
+	var b strings.Builder
+	b.WriteString("User-agent: *\n")
+	for _, name := range append(robotsDisallowed, render.RegisteredRenderers()...) {
+		fmt.Fprintf(&b, "Disallow: /%s/\n", name)
+	}
+	return b.String()
+}
+
+// robotsPage serves robots.txt from the static directory, falling back to
+// defaultRobotsTxt unless serve_robots_txt is off.
+func robotsPage(w http.ResponseWriter, r *http.Request) {
+	err := serveStatic(w, r, "robots.txt")
+	if err == nil {
+		return
+	}
+	if !config.ServeRobotsTxt {
+		notFoundPage(w, r)
+		return
+	}
+
+	w.Header().Add("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, defaultRobotsTxt())
+}
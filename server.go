@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/crypto/acme/autocert"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultTLSListenOn    = ":443"
+	AutocertCacheLocation = "certs"
+)
+
+type TLSConfig struct {
+	Enabled bool `json:"enabled"`
+	// Domain to fetch a Let's Encrypt certificate for, used when no
+	// certificate file is given
+	Domain   string `json:"domain"`
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+	// Address to serve HTTPS on, defaults to DefaultTLSListenOn
+	Listen string `json:"listen,omitempty"`
+}
+
+// runServer serves until the process is asked to stop, then gives in-flight
+// requests up to the configured shutdown timeout to finish.
+//
+// Without TLS, a server is started for every listen address, sharing
+// server's handler. With TLS enabled, server listens on the TLS address and
+// plain HTTP requests to the listen addresses are redirected to it.
+func runServer(server *http.Server) error {
+	var servers []*http.Server
+	plain := server.Handler
+
+	var certManager *autocert.Manager
+	if config.TLS.Enabled {
+		server.Addr = config.TLS.Listen
+		servers = append(servers, server)
+
+		redirect := http.Handler(http.HandlerFunc(redirectToHTTPS))
+		if config.TLS.CertFile == "" {
+			certManager = &autocert.Manager{
+				Prompt:     autocert.AcceptTOS,
+				HostPolicy: autocert.HostWhitelist(config.TLS.Domain),
+				Cache:      autocert.DirCache(AutocertCacheLocation),
+			}
+			server.TLSConfig = certManager.TLSConfig()
+			// Let's Encrypt's challenges arrive over plain HTTP
+			redirect = certManager.HTTPHandler(redirect)
+		}
+		plain = redirect
+	}
+	for _, addr := range config.listenAddrs() {
+		servers = append(servers, &http.Server{Addr: addr, Handler: plain})
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-sigs
+		log.Printf("Received %s, shutting down", sig)
+
+		timeout := time.Duration(config.ShutdownTimeoutSeconds) * time.Second
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		for _, s := range servers {
+			err := s.Shutdown(ctx)
+			if err != nil {
+				log.Println("Unable to drain connections, closing them:", err)
+				s.Close()
+			}
+		}
+	}()
+
+	errs := make(chan error, len(servers))
+	for _, s := range servers {
+		go func(s *http.Server) {
+			var err error
+			if s != server {
+				err = s.ListenAndServe()
+			} else if certManager != nil {
+				err = s.ListenAndServeTLS("", "")
+			} else {
+				err = s.ListenAndServeTLS(config.TLS.CertFile, config.TLS.KeyFile)
+			}
+			if err != http.ErrServerClosed {
+				err = fmt.Errorf("serving on %s: %w", s.Addr, err)
+			}
+			errs <- err
+		}(s)
+	}
+
+	err := <-errs
+	if err != http.ErrServerClosed {
+		return err
+	}
+
+	<-drained
+	return nil
+}
+
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	if _, port, _ := net.SplitHostPort(config.TLS.Listen); port != "443" {
+		host = net.JoinHostPort(host, port)
+	}
+
+	target := "https://" + host + r.URL.RequestURI()
+	http.Redirect(w, r, target, http.StatusMovedPermanently)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"golang.org/x/sync/singleflight"
+	"io"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// How long /username/ lookups are cached for
+	UsernameTTL = 10 * Minutes
+)
+
+// SessionCache keeps the raw session profile JSON Mojang returned for each
+// UUID, so /minecraft/session/ clients share our view of their rate limits.
+type SessionCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]sessionCacheEntry
+	group   singleflight.Group
+}
+
+type sessionCacheEntry struct {
+	data      []byte
+	fetchedAt time.Time
+}
+
+// Mojang's session profiles for /minecraft/session/, and for /username/
+// which holds on to them for less time as names change more than skins
+var (
+	sessionCache  *SessionCache
+	usernameCache *SessionCache
+)
+
+var ErrNoProfile = errors.New("No profile for UUID")
+
+func NewSessionCache(ttl time.Duration) *SessionCache {
+	return &SessionCache{ttl: ttl, entries: make(map[string]sessionCacheEntry)}
+}
+
+// Get returns the profile JSON for uuid, fetching it from Mojang if it isn't
+// cached or has expired.
+func (c *SessionCache) Get(ctx context.Context, uuid string) ([]byte, error) {
+	data, _, err := c.GetWithTime(ctx, uuid)
+	return data, err
+}
+
+// GetWithTime is Get, also returning when the profile was fetched.
+func (c *SessionCache) GetWithTime(ctx context.Context, uuid string) ([]byte, time.Time, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[uuid]
+	c.mu.Unlock()
+	if ok && time.Since(entry.fetchedAt) <= c.ttl {
+		return entry.data, entry.fetchedAt, nil
+	}
+
+	result, err, _ := c.group.Do(uuid, func() (interface{}, error) {
+		return fetchRawSessionProfile(detachContext(ctx), uuid)
+	})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	data := result.([]byte)
+	fetchedAt := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Sweep out anything expired while we hold the lock, so the map only
+	// grows with the players actually being asked for
+	for key, e := range c.entries {
+		if time.Since(e.fetchedAt) > c.ttl {
+			delete(c.entries, key)
+		}
+	}
+	c.entries[uuid] = sessionCacheEntry{data: data, fetchedAt: fetchedAt}
+	return data, fetchedAt, nil
+}
+
+// fetchRawSessionProfile fetches the signed profile of uuid from the
+// session server, leaving the JSON as Mojang sent it.
+func fetchRawSessionProfile(ctx context.Context, uuid string) ([]byte, error) {
+	resp, err := mojangGet(ctx, sessionProfileURL(uuid)+"?unsigned=false")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return nil, newRateLimitedError(resp)
+	} else if resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNoProfile
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Session server returned %s for %s", resp.Status, uuid)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// rateLimitedPage passes on Mojang rate limiting us to the client.
+func rateLimitedPage(w http.ResponseWriter, err RateLimitedError) {
+	w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Ceil(err.RetryAfter.Seconds()))))
+	w.WriteHeader(http.StatusTooManyRequests)
+	fmt.Fprintf(w, "429 too many requests")
+}
+
+// sessionPage proxies Mojang's session profile for a UUID, passing their
+// rate limiting on to the client.
+func sessionPage(w http.ResponseWriter, r *http.Request) {
+	_, uuid := normalizeIdentifier(mux.Vars(r)["uuid"])
+
+	data, err := sessionCache.Get(r.Context(), uuid)
+	if rateLimited, ok := err.(RateLimitedError); ok {
+		rateLimitedPage(w, rateLimited)
+		return
+	} else if err != nil {
+		notFoundPage(w, r)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	addCacheTimeoutHeader(w, config.TTLActualSkinSeconds)
+	w.Write(data)
+}
+
+type UsernameLookup struct {
+	UUID     string    `json:"uuid"`
+	Username string    `json:"username"`
+	CachedAt time.Time `json:"cached_at"`
+}
+
+// usernamePage looks up the current username of the player with a UUID.
+func usernamePage(w http.ResponseWriter, r *http.Request) {
+	_, uuid := normalizeIdentifier(mux.Vars(r)["uuid"])
+
+	var profile SessionProfile
+	data, cachedAt, err := usernameCache.GetWithTime(r.Context(), uuid)
+	if err == nil {
+		err = json.Unmarshal(data, &profile)
+	}
+	if rateLimited, ok := err.(RateLimitedError); ok {
+		rateLimitedPage(w, rateLimited)
+		return
+	} else if err != nil {
+		status := http.StatusBadGateway
+		if err == ErrNoProfile {
+			status = http.StatusNotFound
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	addCacheTimeoutHeader(w, UsernameTTL)
+	json.NewEncoder(w).Encode(UsernameLookup{
+		UUID:     uuid,
+		Username: profile.Name,
+		CachedAt: cachedAt.UTC(),
+	})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/applenick/appletar/render"
+	"image"
+	"image/draw"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// SpriteSheet describes where each player's avatar is in a /sprite image.
+type SpriteSheet struct {
+	Width  uint `json:"width"`
+	Height uint `json:"height"`
+	Size   uint `json:"size"`
+	// CSS background-position of each player's avatar
+	Positions map[string]string `json:"positions"`
+}
+
+// parseSpriteRequest reads the players, avatar size and number of columns
+// asked for from a /sprite or /sprite.json query string.
+func parseSpriteRequest(r *http.Request) (usernames []string, size uint, columns int, err error) {
+	query := r.URL.Query()
+
+	seen := make(map[string]bool)
+	for _, username := range strings.Split(query.Get("users"), ",") {
+		if username == "" {
+			continue
+		}
+		if !batchIdentifierRegex.MatchString(username) {
+			return nil, 0, 0, fmt.Errorf("Invalid username %q", username)
+		}
+		if !seen[username] {
+			seen[username] = true
+			usernames = append(usernames, username)
+		}
+	}
+	if len(usernames) == 0 {
+		return nil, 0, 0, errors.New("No users given")
+	}
+	if len(usernames) > config.BatchMaxUsers {
+		return nil, 0, 0, fmt.Errorf("At most %d users may be requested at once", config.BatchMaxUsers)
+	}
+
+	size = DefaultSize
+	if query.Get("size") != "" {
+		size = rationalizeSize(query.Get("size"))
+	}
+
+	// A single row unless asked otherwise
+	columns = len(usernames)
+	if c, err := strconv.Atoi(query.Get("columns")); err == nil && c > 0 && c < columns {
+		columns = c
+	}
+	return usernames, size, columns, nil
+}
+
+func newSpriteSheet(usernames []string, size uint, columns int) SpriteSheet {
+	rows := (len(usernames) + columns - 1) / columns
+	sheet := SpriteSheet{
+		Width:     uint(columns) * size,
+		Height:    uint(rows) * size,
+		Size:      size,
+		Positions: make(map[string]string, len(usernames)),
+	}
+	for i, username := range usernames {
+		x, y := spriteOffset(i, size, columns)
+		sheet.Positions[username] = fmt.Sprintf("%dpx %dpx", -x, -y)
+	}
+	return sheet
+}
+
+func spriteOffset(i int, size uint, columns int) (int, int) {
+	return (i % columns) * int(size), (i / columns) * int(size)
+}
+
+// spritePage renders the avatars of many players into a single PNG, for use
+// as a CSS sprite sheet.
+func spritePage(w http.ResponseWriter, r *http.Request) {
+	usernames, size, columns, err := parseSpriteRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sheet := newSpriteSheet(usernames, size, columns)
+
+	var fallback image.Image
+	sprite := image.NewNRGBA(image.Rect(0, 0, int(sheet.Width), int(sheet.Height)))
+	for i, img := range renderEach(r.Context(), usernames, size, render.Head) {
+		if img == nil {
+			// Keep the rest of the sheet, showing the default skin here
+			if fallback == nil {
+				head, err := render.Head(fetchFallbackSkin(r.Context()))
+				if err != nil {
+					log.Println("Unable to render fallback avatar for sprite:", err)
+					continue
+				}
+				fallback = render.Resize(size, 0, head, config.ResizeFilter)
+			}
+			img = fallback
+		}
+
+		x, y := spriteOffset(i, size, columns)
+		dst := image.Rect(x, y, x+int(size), y+int(size))
+		draw.Draw(sprite, dst, img, img.Bounds().Min, draw.Src)
+	}
+
+	buf := new(bytes.Buffer)
+	err = render.WritePNG(buf, sprite)
+	if err != nil {
+		serverErrorPage(w, r)
+		return
+	}
+
+	w.Header().Add("Content-Type", "image/png")
+	addCacheTimeoutHeader(w, config.TTLActualSkinSeconds)
+	writeWithETag(w, r, buf.Bytes())
+}
+
+// spriteJSONPage describes the sheet /sprite returns for the same query.
+func spriteJSONPage(w http.ResponseWriter, r *http.Request) {
+	usernames, size, columns, err := parseSpriteRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	addCacheTimeoutHeader(w, config.TTLActualSkinSeconds)
+	json.NewEncoder(w).Encode(newSpriteSheet(usernames, size, columns))
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sync/atomic"
+)
+
+const (
+	// Weight given to each new fetch time in the moving average
+	StatsFetchAlpha = 0.1
+)
+
+// Stats counts what the server has been doing since it started, or since
+// the counters were last reset.
+type Stats struct {
+	totalRequests  uint64
+	cacheHits      uint64
+	cacheMisses    uint64
+	mojangErrors   uint64
+	fallbackServed uint64
+	avgFetchMs     uint64 // float64 bits
+}
+
+type StatsSnapshot struct {
+	TotalRequests  uint64  `json:"total_requests"`
+	CacheHits      uint64  `json:"cache_hits"`
+	CacheMisses    uint64  `json:"cache_misses"`
+	MojangErrors   uint64  `json:"mojang_errors"`
+	FallbackServed uint64  `json:"fallback_served"`
+	AvgFetchMs     float64 `json:"avg_fetch_ms"`
+}
+
+var stats Stats
+
+// RecordFetch folds the time taken by a fetch into the exponentially
+// weighted average.
+func (s *Stats) RecordFetch(ms int64) {
+	for {
+		old := atomic.LoadUint64(&s.avgFetchMs)
+		avg := math.Float64frombits(old)
+		if old == 0 {
+			avg = float64(ms)
+		} else {
+			avg += StatsFetchAlpha * (float64(ms) - avg)
+		}
+		if atomic.CompareAndSwapUint64(&s.avgFetchMs, old, math.Float64bits(avg)) {
+			return
+		}
+	}
+}
+
+func (s *Stats) Snapshot(reset bool) StatsSnapshot {
+	read := atomic.LoadUint64
+	if reset {
+		read = func(addr *uint64) uint64 {
+			return atomic.SwapUint64(addr, 0)
+		}
+	}
+
+	return StatsSnapshot{
+		TotalRequests:  read(&s.totalRequests),
+		CacheHits:      read(&s.cacheHits),
+		CacheMisses:    read(&s.cacheMisses),
+		MojangErrors:   read(&s.mojangErrors),
+		FallbackServed: read(&s.fallbackServed),
+		AvgFetchMs:     math.Float64frombits(read(&s.avgFetchMs)),
+	}
+}
+
+func statsPage(w http.ResponseWriter, r *http.Request) {
+	reset := r.URL.Query().Get("reset_on_read") == "1" || r.URL.Query().Get("reset_on_read") == "true"
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Add("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(stats.Snapshot(reset))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// A StorageBackend stores the raw PNG of each cached skin somewhere.
+type StorageBackend interface {
+	Save(username string, data []byte) error
+	// Load returns the skin's PNG and when it was saved
+	Load(username string) ([]byte, time.Time, error)
+	Delete(username string) error
+}
+
+func newStorageBackend(cfg MinotarConfig) (StorageBackend, error) {
+	switch cfg.StorageType {
+	case "", "disk":
+		return DiskBackend{Dir: SkinCacheLocation}, nil
+	case "s3":
+		return NewS3Backend(cfg.S3Bucket, cfg.S3Region, cfg.S3Prefix)
+	}
+	return nil, fmt.Errorf("Unknown storage type %q", cfg.StorageType)
+}
+
+// DiskBackend keeps each skin as a flat PNG file in Dir. Usernames are case
+// insensitive, so the files are named in lower case.
+type DiskBackend struct {
+	Dir string
+}
+
+func (b DiskBackend) skinPath(username string) string {
+	return path.Join(b.Dir, strings.ToLower(username)+".png")
+}
+
+// Save writes to a temporary file that is renamed into place, so a crash
+// part way through never leaves a truncated skin to be served.
+func (b DiskBackend) Save(username string, data []byte) error {
+	f, err := os.CreateTemp(b.Dir, ".tmp-*-"+strings.ToLower(username)+".png")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), b.skinPath(username))
+}
+
+func (b DiskBackend) Load(username string) ([]byte, time.Time, error) {
+	skinPath := b.skinPath(username)
+
+	info, err := os.Stat(skinPath)
+	if os.IsNotExist(err) {
+		// Skins cached before names were lowercased are still used until
+		// they expire
+		skinPath = path.Join(b.Dir, username+".png")
+		info, err = os.Stat(skinPath)
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	data, err := os.ReadFile(skinPath)
+	return data, info.ModTime(), err
+}
+
+func (b DiskBackend) Delete(username string) error {
+	for _, skinPath := range []string{b.skinPath(username), path.Join(b.Dir, username+".png")} {
+		err := os.Remove(skinPath)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
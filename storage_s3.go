@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"io"
+	"time"
+)
+
+// S3Backend keeps each skin as an object named <prefix><username>.png in an
+// S3 compatible bucket. Credentials come from the usual AWS environment
+// variables, shared config files or instance role.
+type S3Backend struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+func NewS3Backend(bucket, region, prefix string) (*S3Backend, error) {
+	cfg, err := awsconfig.LoadDefaultConfig(context.Background(), awsconfig.WithRegion(region))
+	if err != nil {
+		return nil, err
+	}
+	return &S3Backend{client: s3.NewFromConfig(cfg), bucket: bucket, prefix: prefix}, nil
+}
+
+func (b *S3Backend) key(username string) *string {
+	return aws.String(b.prefix + username + ".png")
+}
+
+func (b *S3Backend) Save(username string, data []byte) error {
+	_, err := b.client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket:      aws.String(b.bucket),
+		Key:         b.key(username),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("image/png"),
+	})
+	return err
+}
+
+func (b *S3Backend) Load(username string) ([]byte, time.Time, error) {
+	out, err := b.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    b.key(username),
+	})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer out.Body.Close()
+
+	data, err := io.ReadAll(out.Body)
+	return data, aws.ToTime(out.LastModified), err
+}
+
+func (b *S3Backend) Delete(username string) error {
+	_, err := b.client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    b.key(username),
+	})
+	return err
+}
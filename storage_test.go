@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestDiskBackendSave(t *testing.T) {
+	dir := t.TempDir()
+	backend := DiskBackend{Dir: dir}
+
+	for _, data := range [][]byte{[]byte("first"), []byte("second")} {
+		err := backend.Save("Notch", data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loaded, _, err := backend.Load("Notch")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(loaded, data) {
+			t.Errorf("Expected %q to be loaded, got %q", data, loaded)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "notch.png" {
+		t.Errorf("Expected only notch.png to be left behind, got %v", entries)
+	}
+}
+
+func TestDiskBackendSaveError(t *testing.T) {
+	dir := t.TempDir()
+	err := os.Chmod(dir, 0555)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(dir, 0755)
+	if checkWritable(dir) == nil {
+		t.Skip("Read-only directories are still writable, probably running as root")
+	}
+
+	backend := DiskBackend{Dir: dir}
+	err = backend.Save("Notch", []byte("not really a skin"))
+	if err == nil {
+		t.Error("Expected saving to a read-only directory to fail")
+	}
+
+	err = StorageCache{Backend: backend}.Save("Notch", loadFixtureSkin(t))
+	if err == nil {
+		t.Error("Expected StorageCache to pass on the failure to save")
+	}
+}
+
+func TestStaticTileCacheDelete(t *testing.T) {
+	dir := t.TempDir()
+	backend := DiskBackend{Dir: dir}
+
+	// Tiles saved by an earlier run, which a new cache knows nothing about
+	old := &StaticTileCache{Backend: backend}
+	for _, kind := range []string{"avatar", "helm/overlay"} {
+		err := old.Save("Notch", 64, kind, []byte("tile"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := old.Save("jeb_", 64, "avatar", []byte("tile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = (&StaticTileCache{Backend: backend}).Delete("Notch")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "tile-avatar-64-jeb_.png" {
+		t.Errorf("Expected only jeb_'s tile to be left behind, got %v", entries)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// TileSizes are the sizes StaticTileCache keeps rendered PNGs for.
+var TileSizes = []uint{16, 32, 64, 128, 256}
+
+// StaticTileCache keeps the finished PNG of each (username, size, type) in
+// the skin cache directory, so the most common requests skip rendering and
+// resizing entirely. Tiles are rendered lazily on first request and expire
+// along with the skin.
+type StaticTileCache struct {
+	Backend DiskBackend
+}
+
+var tileCache *StaticTileCache
+
+func isTileSize(size uint) bool {
+	for _, s := range TileSizes {
+		if s == size {
+			return true
+		}
+	}
+	return false
+}
+
+// tileKey names a tile by the normalized identifier, which is what Delete
+// is given however the player was asked for.
+func tileKey(identifier string, size uint, kind string) string {
+	_, id := normalizeIdentifier(identifier)
+	return fmt.Sprintf("tile-%s-%d-%s", strings.Replace(kind, "/", "-", -1), size, id)
+}
+
+func (c *StaticTileCache) Get(username string, size uint, kind string) ([]byte, error) {
+	data, savedAt, err := c.Backend.Load(tileKey(username, size, kind))
+	if err != nil {
+		return nil, err
+	}
+	if time.Since(savedAt) > time.Duration(config.TTLActualSkinSeconds)*time.Second {
+		return nil, ErrSkinExpired
+	}
+	return data, nil
+}
+
+func (c *StaticTileCache) Save(username string, size uint, kind string, data []byte) error {
+	return c.Backend.Save(tileKey(username, size, kind), data)
+}
+
+// Delete removes every tile of the player's skin. The directory is searched
+// for them, as they may have been saved before we were started.
+func (c *StaticTileCache) Delete(username string) error {
+	var err error
+	for _, size := range TileSizes {
+		// Tiles saved before names were lowercased keep the name's case
+		for _, name := range []string{strings.ToLower(username), username} {
+			paths, _ := filepath.Glob(filepath.Join(c.Backend.Dir, fmt.Sprintf("tile-*-%d-%s.png", size, name)))
+			for _, p := range paths {
+				if e := os.Remove(p); e != nil && !os.IsNotExist(e) {
+					err = e
+				}
+			}
+		}
+	}
+	return err
+}
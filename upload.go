@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/applenick/appletar/render"
+	"github.com/applenick/minecraft"
+	"image/png"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// Uploaded skins share the storage backend, under keys no username can
+	// collide with
+	UploadStoragePrefix = "upload-"
+
+	MaxUploadBytes = 100 * 1024
+)
+
+// uploadStorage holds skins uploaded through POST /skin/upload, and is nil
+// when uploads are disabled.
+var uploadStorage StorageBackend
+
+// uploadPage stores a skin PNG for a username, which is then served instead
+// of the player's Mojang skin. It requires the configured token as a bearer
+// token, and doesn't exist at all if no token is configured.
+func uploadPage(w http.ResponseWriter, r *http.Request) {
+	if config.SkinUploadToken == "" || uploadStorage == nil {
+		notFoundPage(w, r)
+		return
+	}
+	if !checkBearerToken(w, r, config.SkinUploadToken) {
+		return
+	}
+
+	// Leave room for the rest of the form around the file
+	r.Body = http.MaxBytesReader(w, r.Body, MaxUploadBytes+4096)
+	err := r.ParseMultipartForm(MaxUploadBytes)
+	if err != nil {
+		http.Error(w, "Malformed upload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	identifier := r.FormValue("username")
+	if !batchIdentifierRegex.MatchString(identifier) {
+		http.Error(w, "Invalid username", http.StatusBadRequest)
+		return
+	}
+	_, username := normalizeIdentifier(identifier)
+
+	file, header, err := r.FormFile("skin")
+	if err != nil {
+		http.Error(w, "No skin uploaded", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	if header.Size > MaxUploadBytes {
+		http.Error(w, "Skin must be at most 100 KB", http.StatusBadRequest)
+		return
+	}
+
+	img, err := png.Decode(file)
+	if err != nil {
+		http.Error(w, "Skin is not a valid PNG", http.StatusBadRequest)
+		return
+	}
+	if !render.ValidSkinSize(img) {
+		http.Error(w, "Skin must be 64x64 or 64x32", http.StatusBadRequest)
+		return
+	}
+
+	// Stored as /skin/ serves it, so the hash matches /skin/{username}/hash
+	data, err := encodeSkin(minecraft.Skin{Image: img})
+	if err == nil {
+		err = uploadStorage.Save(UploadStoragePrefix+username, data)
+	}
+	if err == nil {
+		// Forget any copy of their Mojang skin
+		err = invalidateSkin(username)
+	}
+	if err != nil {
+		log.Printf("Unable to save uploaded skin for %s: %s", username, err)
+		serverErrorPage(w, r)
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(SkinHash{
+		Username:  username,
+		SHA256:    hex.EncodeToString(sum[:]),
+		FetchedAt: time.Now().UTC(),
+	})
+}
+
+// UploadSource finds skins uploaded through POST /skin/upload.
+type UploadSource struct {
+	Storage StorageBackend
+}
+
+func (s UploadSource) FetchSkin(ctx context.Context, username string) (minecraft.Skin, bool, error) {
+	data, _, err := s.Storage.Load(UploadStoragePrefix + username)
+	if err != nil {
+		// Most players won't have uploaded a skin
+		return minecraft.Skin{}, false, nil
+	}
+
+	skin, err := decodeSkin(data)
+	if err != nil {
+		return minecraft.Skin{}, false, err
+	}
+	cacheInMemory(username, skin)
+	return skin, true, nil
+}
+
+func (UploadSource) Status() CacheStatus { return CacheStatusUpload }
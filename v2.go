@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// V2Avatar is the /v2/avatar/ response, describing the avatar alongside the
+// image itself.
+type V2Avatar struct {
+	Username    string      `json:"username"`
+	Size        uint        `json:"size"`
+	ImageURL    string      `json:"image_url"`
+	DataURI     string      `json:"data_uri"`
+	FetchedAt   time.Time   `json:"fetched_at"`
+	CacheStatus CacheStatus `json:"cache_status"`
+}
+
+// registerV2Routes adds the versioned API under /v2/. Its responses are JSON
+// envelopes, leaving the unversioned routes to serve raw images.
+func registerV2Routes(r *mux.Router) {
+	v2 := r.PathPrefix("/v2").Subrouter()
+	v2.HandleFunc("/avatar/{username:"+ValidIdentifierRegex+"}", v2AvatarPage)
+}
+
+// publicURL is the absolute URL of path on this server, or on the CDN if one
+// is configured.
+func publicURL(r *http.Request, path string) string {
+	if config.CDNBaseURL != "" {
+		return strings.TrimSuffix(config.CDNBaseURL, "/") + path
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + path
+}
+
+// v2JSONError sends an error as a JSON body.
+func v2JSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+func v2AvatarPage(w http.ResponseWriter, r *http.Request) {
+	if format := r.URL.Query().Get("format"); format != "" && format != "json" {
+		v2JSONError(w, http.StatusBadRequest, fmt.Sprintf("unsupported format %q", format))
+		return
+	}
+
+	username := mux.Vars(r)["username"]
+	size := requestedSize(r)
+	data, cacheStatus, err := renderAvatarPNG(w, r)
+	if err != nil {
+		v2JSONError(w, http.StatusInternalServerError, "unable to render avatar")
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	setResultHeaders(w, cacheStatus)
+	json.NewEncoder(w).Encode(V2Avatar{
+		Username:    username,
+		Size:        size,
+		ImageURL:    publicURL(r, fmt.Sprintf("/avatar/%s/%d.png", username, size)),
+		DataURI:     "data:image/png;base64," + base64.StdEncoding.EncodeToString(data),
+		FetchedAt:   time.Now().UTC(),
+		CacheStatus: cacheStatus,
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// warmCache fetches the skin of every username listed in the file at path,
+// one per line, so that they are cached before anyone asks for them. At most
+// concurrency fetches run at once.
+func warmCache(path string, concurrency int) {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Println("Unable to open prefetch list:", err)
+		return
+	}
+	defer f.Close()
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	count := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		username := strings.TrimSpace(scanner.Text())
+		if username == "" || strings.HasPrefix(username, "#") {
+			continue
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(username string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fetchSkin(context.Background(), skinFetcher, username)
+		}(username)
+		count++
+	}
+	wg.Wait()
+
+	if err := scanner.Err(); err != nil {
+		log.Println("Unable to read prefetch list:", err)
+	}
+	log.Printf("Prefetched %d skins from %s", count, path)
+}
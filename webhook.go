@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	MaxWebhookBytes = 64 * 1024
+
+	// Attempts at fetching a changed skin, waiting WebhookRetryDelay before
+	// the second and twice as long before each one after that
+	WebhookFetchAttempts = 3
+	WebhookRetryDelay    = 2 * time.Second
+)
+
+type SkinChange struct {
+	Username string `json:"username"`
+	UUID     string `json:"uuid"`
+}
+
+// skinChangeWebhookPage forgets a player's cached skin when told it has
+// changed, then fetches the new one in the background. The body must be
+// signed with the configured secret in X-Webhook-Signature, and the hook
+// doesn't exist at all if no secret is configured.
+func skinChangeWebhookPage(w http.ResponseWriter, r *http.Request) {
+	if config.WebhookSecret == "" {
+		notFoundPage(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxWebhookBytes))
+	if err != nil {
+		http.Error(w, "Unable to read body", http.StatusBadRequest)
+		return
+	}
+	if !validWebhookSignature(body, r.Header.Get("X-Webhook-Signature")) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var change SkinChange
+	err = json.Unmarshal(body, &change)
+	if err != nil {
+		http.Error(w, "Malformed skin change: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var identifiers []string
+	for _, identifier := range []string{change.UUID, change.Username} {
+		if identifier == "" {
+			continue
+		}
+		if !batchIdentifierRegex.MatchString(identifier) {
+			http.Error(w, "Invalid username or UUID", http.StatusBadRequest)
+			return
+		}
+		_, identifier = normalizeIdentifier(identifier)
+		identifiers = append(identifiers, identifier)
+	}
+	if len(identifiers) == 0 {
+		http.Error(w, "No username or UUID given", http.StatusBadRequest)
+		return
+	}
+
+	// The skin may be cached under either
+	for _, identifier := range identifiers {
+		err = invalidateSkin(identifier)
+		if err != nil {
+			log.Printf("Unable to invalidate skin for %s: %s", identifier, err)
+			serverErrorPage(w, r)
+			return
+		}
+	}
+	for _, identifier := range identifiers {
+		go warmChangedSkin(detachContext(r.Context()), identifier)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// validWebhookSignature checks signature is the hex HMAC-SHA256 of body
+// keyed with the webhook secret, optionally prefixed with "sha256=".
+func validWebhookSignature(body []byte, signature string) bool {
+	given, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(config.WebhookSecret))
+	mac.Write(body)
+	return hmac.Equal(given, mac.Sum(nil))
+}
+
+// warmChangedSkin fetches a changed skin into the caches, backing off
+// exponentially between attempts.
+func warmChangedSkin(ctx context.Context, identifier string) {
+	delay := WebhookRetryDelay
+	for attempt := 1; ; attempt++ {
+		_, err := fetchAndCache(ctx, skinFetcher, identifier)
+		if err == nil {
+			return
+		}
+		if attempt == WebhookFetchAttempts {
+			log.Printf("Giving up fetching changed skin for %s: %s", identifier, err)
+			return
+		}
+
+		time.Sleep(delay)
+		delay *= 2
+	}
+}